@@ -16,20 +16,76 @@ import (
 	"github.com/99designs/keyring"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
 
 	"github.com/endorama/2ami/internal/aegis"
+	"github.com/endorama/2ami/internal/otp"
 )
 
 const (
-	backupFormat2ami  = "2ami"
-	backupFormatAegis = "aegis"
+	backupFormat2ami            = "2ami"
+	backupFormatAegis           = "aegis"
+	backupFormatGoogleMigration = "google-migration"
 )
 
+// backup is a key as stored in a 2ami backup. Secret is the base32 secret as
+// held in the keyring.
+//
+// Legacy v1 backups only carry Name, Digits, Interval and Secret, keys without
+// a Type are restored as TOTP.
 type backup struct {
-	Name     string `json:"name"`
-	Digits   string `json:"digits"`
-	Interval string `json:"interval"`
-	Secret   string `json:"secret"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type,omitempty"`
+	Digits    string   `json:"digits"`
+	Interval  string   `json:"interval"`
+	Counter   string   `json:"counter,omitempty"`
+	Algorithm string   `json:"algorithm,omitempty"`
+	T0        string   `json:"t0,omitempty"`
+	Issuer    string   `json:"issuer,omitempty"`
+	Account   string   `json:"account,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	Favorite  bool     `json:"favorite,omitempty"`
+	Secret    string   `json:"secret"`
+}
+
+// options returns the keyOptions to restore the backed up key with.
+func (b backup) options() keyOptions {
+	options := keyOptions{}
+	if b.Type != "" {
+		options.Type = b.Type
+	}
+	if b.Digits != "" {
+		options.Digits = b.Digits
+	}
+	if b.Interval != "" {
+		options.Interval = b.Interval
+	}
+	if b.Counter != "" {
+		options.Counter = b.Counter
+	}
+	if b.Algorithm != "" {
+		options.Algorithm = b.Algorithm
+	}
+	if b.T0 != "" {
+		options.T0 = b.T0
+	}
+	if b.Issuer != "" {
+		options.Issuer = b.Issuer
+	}
+	if b.Account != "" {
+		options.Account = b.Account
+	}
+	if len(b.Tags) > 0 {
+		options.Tags = b.Tags
+	}
+	if b.Note != "" {
+		options.Note = b.Note
+	}
+	if b.Favorite {
+		options.Favorite = true
+	}
+	return options
 }
 
 func backupAllKeys(storage Storage, password string) (string, error) {
@@ -43,63 +99,58 @@ func backupAllKeys(storage Storage, password string) (string, error) {
 		return "", err
 	}
 
-	allKeys := make([]string, 0)
+	allKeys := make([]backup, 0, len(keys))
 
 	for _, v := range keys {
-		value, err := backupKeyFromRing(storage, ring, v, password)
+		value, err := backupKeyFromRing(storage, ring, v)
 		if err != nil {
 			return "", err
 		}
 		allKeys = append(allKeys, value)
 	}
 
-	return strings.Join(allKeys, "."), nil
+	return encryptBackupFile(allKeys, password)
 }
 
-func restore(storage Storage, input string, password string, format string) error {
+// readBackup returns the keys in a backup of the given format, and the ones
+// that cannot be imported.
+func readBackup(input string, password string, format string) ([]importedKey, []skippedKey, error) {
 	switch format {
 	case backupFormat2ami:
-		return restore2ami(storage, input, password)
+		return read2amiBackup(input, password)
 	case backupFormatAegis:
-		return restoreAegis(storage, input, password)
+		return readAegisBackup(input, password)
+	case backupFormatGoogleMigration:
+		return readGoogleMigration(input)
 	default:
-		return fmt.Errorf("unsupported backup format: %s", format)
+		return nil, nil, fmt.Errorf("unsupported backup format: %s", format)
 	}
 }
 
-func restore2ami(storage Storage, input string, password string) error {
-	sections := strings.Split(input, ".")
-
-	for _, section := range sections {
-		b, err := decryptBackup(section, password)
-		if err != nil {
-			return err
-		}
-
-		var digits interface{}
-		if b.Digits != "" {
-			digits = b.Digits
-		}
-
-		var interval interface{}
-		if b.Interval != "" {
-			interval = b.Interval
-		}
+func read2amiBackup(input string, password string) ([]importedKey, []skippedKey, error) {
+	entries, err := decryptBackupFile(input, password)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		err = add(storage, b.Name, b.Secret, digits, interval)
-		if err != nil {
-			return err
+	keys := make([]importedKey, 0, len(entries))
+	var skipped []skippedKey
+	for _, b := range entries {
+		if err := isValidBase32(b.Secret); err != nil {
+			skipped = append(skipped, skippedKey{Name: b.Name, Reason: fmt.Sprintf("invalid base32 secret: %s", err)})
+			continue
 		}
+		keys = append(keys, importedKey{Name: b.Name, Secret: b.Secret, Options: b.options()})
 	}
 
-	return nil
+	return keys, skipped, nil
 }
 
-func restoreAegis(storage Storage, input string, password string) error {
+func readAegisBackup(input string, password string) ([]importedKey, []skippedKey, error) {
 	// Parse the Aegis backup
 	backup, err := aegis.ParseBackup([]byte(input))
 	if err != nil {
-		return fmt.Errorf("failed to parse Aegis backup: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse Aegis backup: %w", err)
 	}
 
 	var db *aegis.DB
@@ -109,124 +160,286 @@ func restoreAegis(storage Storage, input string, password string) error {
 		// Decrypt the backup
 		db, err = backup.DecryptBackup(password)
 		if err != nil {
-			return fmt.Errorf("failed to decrypt Aegis backup: %w", err)
+			return nil, nil, fmt.Errorf("failed to decrypt Aegis backup: %w", err)
 		}
 	} else {
 		// Parse plain text backup
 		db, err = backup.ParsePlainBackup()
 		if err != nil {
-			return fmt.Errorf("failed to parse plain Aegis backup: %w", err)
+			return nil, nil, fmt.Errorf("failed to parse plain Aegis backup: %w", err)
 		}
 	}
 
-	// Import the entries
-	return importAegisEntries(storage, db.Entries)
+	keys, skipped := aegisKeys(db.Entries, db.Groups)
+	return keys, skipped, nil
 }
 
-func importAegisEntries(storage Storage, entries []aegis.Entry) error {
+func aegisKeys(entries []aegis.Entry, groups []aegis.Group) ([]importedKey, []skippedKey) {
+	keys := make([]importedKey, 0, len(entries))
+	var skipped []skippedKey
+
+	groupNames := make(map[string]string, len(groups))
+	for _, group := range groups {
+		groupNames[group.UUID] = group.Name
+	}
+
 	for _, entry := range entries {
+		// Determine entry name (use issuer + name if available)
+		entryName := otpauthKeyName(entry.Issuer, entry.Name)
+
 		// Extract secret from info
 		secretInterface, ok := entry.Info["secret"]
 		if !ok {
-			debugPrint(fmt.Sprintf("Skipping entry '%s' - no secret found", entry.Name))
+			skipped = append(skipped, skippedKey{Name: entryName, Reason: "no secret found"})
 			continue
 		}
 
 		secret, ok := secretInterface.(string)
 		if !ok {
-			debugPrint(fmt.Sprintf("Skipping entry '%s' - invalid secret type", entry.Name))
+			skipped = append(skipped, skippedKey{Name: entryName, Reason: "invalid secret type"})
 			continue
 		}
 
 		// Validate the secret is base32
 		if err := isValidBase32(secret); err != nil {
-			debugPrint(fmt.Sprintf("Skipping entry '%s' - invalid base32 secret: %v", entry.Name, err))
+			skipped = append(skipped, skippedKey{Name: entryName, Reason: fmt.Sprintf("invalid base32 secret: %s", err)})
 			continue
 		}
 
-		// Determine entry name (use issuer + name if available)
-		entryName := entry.Name
+		options := keyOptions{Account: entry.Name}
 		if entry.Issuer != "" {
-			entryName = entry.Issuer + " - " + entry.Name
+			options.Issuer = entry.Issuer
+		}
+
+		// Groups are stored as tags, by name
+		var tags []string
+		for _, uuid := range entry.Groups {
+			if name, ok := groupNames[uuid]; ok {
+				tags = append(tags, name)
+			}
+		}
+		if len(tags) > 0 {
+			options.Tags = tags
+		}
+		if entry.Note != "" {
+			options.Note = entry.Note
+		}
+		if entry.Favorite {
+			options.Favorite = true
 		}
 
 		// Extract digits
-		var digits interface{}
 		if digitsInterface, ok := entry.Info["digits"]; ok {
 			if digitsFloat, ok := digitsInterface.(float64); ok {
-				digits = int(digitsFloat)
+				options.Digits = int(digitsFloat)
 			}
 		}
 
+		// Extract type and counter
+		if entry.Type == "hotp" {
+			options.Type = HOTP_TOKEN
+			if counterInterface, ok := entry.Info["counter"]; ok {
+				if counterFloat, ok := counterInterface.(float64); ok {
+					options.Counter = int(counterFloat)
+				}
+			}
+		}
+
+		if entry.Type == "steam" {
+			options.Type = STEAM_TOKEN
+		}
+
 		// Extract interval/period
-		var interval interface{}
-		if entry.Type == "totp" {
+		if entry.Type == "totp" || entry.Type == "steam" {
 			if periodInterface, ok := entry.Info["period"]; ok {
 				if periodFloat, ok := periodInterface.(float64); ok {
-					interval = int(periodFloat)
+					options.Interval = int(periodFloat)
 				}
 			}
 		}
 
-		// Add the entry
-		debugPrint(fmt.Sprintf("Adding entry: %s, %s, %s, %s", entryName, secret, digits, interval))
-		err := add(storage, entryName, secret, digits, interval)
-		if err != nil {
-			debugPrint(fmt.Sprintf("Failed to add entry '%s': %v", entryName, err))
-			continue
+		// Extract algorithm
+		if algoInterface, ok := entry.Info["algo"]; ok {
+			if algo, ok := algoInterface.(string); ok {
+				options.Algorithm = algo
+			}
 		}
 
-		debugPrint(fmt.Sprintf("Successfully imported entry: %s", entryName))
+		keys = append(keys, importedKey{Name: entryName, Secret: secret, Options: options})
 	}
 
-	return nil
+	return keys, skipped
 }
 
-func backupKeyFromRing(storage Storage, ring keyring.Keyring, keyName string, password string) (string, error) {
-	debugPrint(fmt.Sprintf("Retrieving and encrypting key '%v' for backup", keyName))
+// backupAllKeysAegis returns all keys as an encrypted Aegis vault.
+func backupAllKeysAegis(storage Storage, password string) (string, error) {
+	ring, err := openKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	keys, err := storage.ListKey()
+	if err != nil {
+		return "", err
+	}
 
-	key := KeyFromStorage(storage, ring, keyName)
+	db := &aegis.DB{
+		Version: aegis.DBVersion,
+		Entries: make([]aegis.Entry, 0, len(keys)),
+		Groups:  []aegis.Group{},
+	}
+	// tags are exported as groups, identified by UUID
+	groupUUIDs := map[string]string{}
+	for _, v := range keys {
+		key := KeyFromStorage(storage, ring, v)
+		secret, err := key.secret.Value()
+		if err != nil {
+			return "", err
+		}
+		entry, err := aegisEntry(key, string(secret))
+		if err != nil {
+			return "", fmt.Errorf("cannot export %s: %w", v, err)
+		}
+		for _, tag := range key.Tags {
+			uuid, ok := groupUUIDs[tag]
+			if !ok {
+				uuid, err = aegis.NewUUID()
+				if err != nil {
+					return "", err
+				}
+				groupUUIDs[tag] = uuid
+				db.Groups = append(db.Groups, aegis.Group{UUID: uuid, Name: tag})
+			}
+			entry.Groups = append(entry.Groups, uuid)
+		}
+		db.Entries = append(db.Entries, entry)
+	}
 
-	rawSecret, err := key.secret.Value()
+	vault, err := aegis.EncryptBackup(db, password)
 	if err != nil {
 		return "", err
 	}
+	encoded, err := json.MarshalIndent(vault, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
 
-	secret := base32.StdEncoding.EncodeToString(rawSecret)
+// aegisEntry returns key as an Aegis vault entry.
+func aegisEntry(key Key, secret string) (aegis.Entry, error) {
+	uuid, err := aegis.NewUUID()
+	if err != nil {
+		return aegis.Entry{}, err
+	}
+	// Aegis expects unpadded, upper case base32
+	decoded, err := otp.DecodeSecret(secret)
+	if err != nil {
+		return aegis.Entry{}, fmt.Errorf("invalid secret: %w", err)
+	}
 
-	b := backup{
+	algorithm := key.Algorithm
+	if algorithm == "" {
+		algorithm = SHA1_ALGORITHM
+	}
+
+	entry := aegis.Entry{
+		Type:     key.Type.String(),
+		UUID:     uuid,
 		Name:     key.Name,
-		Digits:   strconv.Itoa(key.Digits),
-		Interval: strconv.Itoa(key.Interval),
-		Secret:   secret,
+		Issuer:   key.Issuer,
+		Note:     key.Note,
+		Favorite: key.Favorite,
+		Info: map[string]interface{}{
+			"secret": base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(decoded),
+			"algo":   string(algorithm),
+			"digits": key.Digits,
+		},
+		Groups: []string{},
+	}
+	if key.Account != "" {
+		entry.Name = key.Account
+	}
+
+	switch key.Type {
+	case TOTP_TOKEN, STEAM_TOKEN:
+		entry.Info["period"] = key.Interval
+	case HOTP_TOKEN:
+		entry.Info["counter"] = key.Counter
+	default:
+		return aegis.Entry{}, fmt.Errorf("unsupported key type: %s", key.Type)
 	}
 
-	return encryptBackup(b, password)
+	return entry, nil
 }
 
-func encryptBackup(value backup, password string) (string, error) {
-	encoded, err := json.Marshal(value)
+func backupKeyFromRing(storage Storage, ring keyring.Keyring, keyName string) (backup, error) {
+	debugPrint(fmt.Sprintf("Retrieving key '%v' for backup", keyName))
+
+	key := KeyFromStorage(storage, ring, keyName)
+
+	secret, err := key.secret.Value()
 	if err != nil {
-		return "", err
+		return backup{}, err
 	}
 
-	c, err := aes.NewCipher(deriveKey(password))
-	if err != nil {
-		return "", err
+	b := backup{
+		Name:      key.Name,
+		Type:      key.Type.String(),
+		Digits:    strconv.Itoa(key.Digits),
+		Interval:  strconv.Itoa(key.Interval),
+		Counter:   strconv.Itoa(key.Counter),
+		Algorithm: string(key.Algorithm),
+		Issuer:    key.Issuer,
+		Account:   key.Account,
+		Tags:      key.Tags,
+		Note:      key.Note,
+		Favorite:  key.Favorite,
+		Secret:    string(secret),
+	}
+	if key.T0 != 0 {
+		b.T0 = strconv.FormatInt(key.T0, 10)
 	}
 
-	gcm, err := cipher.NewGCM(c)
-	if err != nil {
-		return "", err
+	return b, nil
+}
+
+// decryptBackupFile returns the keys in a 2ami backup, in either the current
+// or the legacy format.
+func decryptBackupFile(input string, password string) ([]backup, error) {
+	if strings.HasPrefix(strings.TrimSpace(input), "{") {
+		return decryptBackupV2(input, password)
 	}
+	return decryptBackupV1(input, password)
+}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
+// Backup format v1, superseded by v2 and only read for compatibility.
+//
+// Each key is JSON encoded, sealed with AES-256-GCM and base64 encoded, keys
+// are joined by a dot. The encryption key is derived from the password with
+// PBKDF2, using no salt and 1000 iterations. Secrets are base32 encoded once
+// more on top of their keyring value.
+
+func decryptBackupV1(input string, password string) ([]backup, error) {
+	sections := strings.Split(input, ".")
+
+	entries := make([]backup, 0, len(sections))
+	for _, section := range sections {
+		b, err := decryptBackup(section, password)
+		if err != nil {
+			return nil, err
+		}
+
+		secret, err := base32.StdEncoding.DecodeString(b.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret for %s: %w", b.Name, err)
+		}
+		b.Secret = string(secret)
+
+		entries = append(entries, b)
 	}
 
-	sealed := gcm.Seal(nonce, nonce, encoded, nil)
-	return base64.StdEncoding.EncodeToString(sealed), nil
+	return entries, nil
 }
 
 func decryptBackup(value string, password string) (backup, error) {
@@ -267,3 +480,174 @@ func decryptBackup(value string, password string) (backup, error) {
 func deriveKey(passphrase string) []byte {
 	return pbkdf2.Key([]byte(passphrase), nil, 1000, 32, sha256.New)
 }
+
+// Backup format v2.
+//
+// The backup is a JSON document: a header describing how the payload has been
+// encrypted, followed by the payload itself. The payload is the JSON list of
+// keys, sealed with AES-256-GCM using a key derived from the password with
+// scrypt and a random salt. The header is authenticated as additional data,
+// so its parameters cannot be tampered with.
+
+const (
+	backupMagic     = "2ami-backup"
+	backupVersion   = 2
+	backupKDFScrypt = "scrypt"
+	backupCipher    = "aes-256-gcm"
+	backupSaltSize  = 32
+	backupKeySize   = 32
+)
+
+// Default scrypt parameters, as recommended for interactive logins in 2017
+// and as used by Aegis.
+const (
+	backupScryptN = 32768
+	backupScryptR = 8
+	backupScryptP = 1
+)
+
+// Limits of the scrypt parameters read from a backup header. The header is
+// authenticated only once the key is derived, so a crafted one must not be
+// able to exhaust memory.
+const (
+	backupScryptMaxN = 1 << 20
+	backupScryptMaxR = 32
+	backupScryptMaxP = 16
+)
+
+type backupKDF struct {
+	Name string `json:"name"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt []byte `json:"salt"`
+}
+
+type backupHeader struct {
+	Magic   string    `json:"magic"`
+	Version int       `json:"version"`
+	KDF     backupKDF `json:"kdf"`
+	Cipher  string    `json:"cipher"`
+	Nonce   []byte    `json:"nonce"`
+}
+
+type backupFile struct {
+	backupHeader
+	Payload []byte `json:"payload"`
+}
+
+type backupPayload struct {
+	Keys []backup `json:"keys"`
+}
+
+func encryptBackupFile(entries []backup, password string) (string, error) {
+	header := backupHeader{
+		Magic:   backupMagic,
+		Version: backupVersion,
+		KDF: backupKDF{
+			Name: backupKDFScrypt,
+			N:    backupScryptN,
+			R:    backupScryptR,
+			P:    backupScryptP,
+			Salt: make([]byte, backupSaltSize),
+		},
+		Cipher: backupCipher,
+	}
+	if _, err := io.ReadFull(rand.Reader, header.KDF.Salt); err != nil {
+		return "", err
+	}
+
+	gcm, err := backupAEAD(header.KDF, password)
+	if err != nil {
+		return "", err
+	}
+	header.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, header.Nonce); err != nil {
+		return "", err
+	}
+
+	plaintext, err := json.Marshal(backupPayload{Keys: entries})
+	if err != nil {
+		return "", err
+	}
+	additionalData, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	file := backupFile{
+		backupHeader: header,
+		Payload:      gcm.Seal(nil, header.Nonce, plaintext, additionalData),
+	}
+	encoded, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func decryptBackupV2(input string, password string) ([]backup, error) {
+	file := backupFile{}
+	if err := json.Unmarshal([]byte(input), &file); err != nil {
+		return nil, fmt.Errorf("cannot parse backup: %w", err)
+	}
+	if file.Magic != backupMagic {
+		return nil, errors.New("not a 2ami backup")
+	}
+	if file.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version: %d", file.Version)
+	}
+	if file.Cipher != backupCipher {
+		return nil, fmt.Errorf("unsupported backup cipher: %s", file.Cipher)
+	}
+
+	gcm, err := backupAEAD(file.KDF, password)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce size, cannot decrypt backup")
+	}
+
+	additionalData, err := json.Marshal(file.backupHeader)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Payload, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt backup, is the password correct? %w", err)
+	}
+
+	payload := backupPayload{}
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("cannot parse backup payload: %w", err)
+	}
+	return payload.Keys, nil
+}
+
+// backupAEAD derives the encryption key from password and returns the cipher
+// to seal or open the backup payload.
+func backupAEAD(kdf backupKDF, password string) (cipher.AEAD, error) {
+	if kdf.Name != backupKDFScrypt {
+		return nil, fmt.Errorf("unsupported backup key derivation: %s", kdf.Name)
+	}
+	if kdf.N <= 1 || kdf.N > backupScryptMaxN || kdf.N&(kdf.N-1) != 0 {
+		return nil, fmt.Errorf("unsupported scrypt N: %d", kdf.N)
+	}
+	if kdf.R <= 0 || kdf.R > backupScryptMaxR {
+		return nil, fmt.Errorf("unsupported scrypt r: %d", kdf.R)
+	}
+	if kdf.P <= 0 || kdf.P > backupScryptMaxP {
+		return nil, fmt.Errorf("unsupported scrypt p: %d", kdf.P)
+	}
+	key, err := scrypt.Key([]byte(password), kdf.Salt, kdf.N, kdf.R, kdf.P, backupKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive backup key: %w", err)
+	}
+
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
@@ -0,0 +1,276 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+
+	"github.com/endorama/2ami/internal/otp"
+)
+
+// doctorProblem is an inconsistency between the bolt database and the
+// keyring.
+type doctorProblem struct {
+	Name  string
+	Issue string
+	// Fix describes how the problem is fixed, empty when it cannot be.
+	Fix string
+	fix func() error
+	// Fixed and FixErr are set once a fix has been attempted.
+	Fixed  bool
+	FixErr error
+}
+
+const issueMissingSecret = "missing secret"
+
+// doctor cross checks every key in storage with its secret in the keyring,
+// and looks for 2ami keyring items no key refers to. With fix set, problems
+// that can be fixed are.
+func doctor(ui cli.Ui, storage Storage, fix bool) ([]doctorProblem, error) {
+	ring, err := openKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open keyring: %w", err)
+	}
+	names, err := storage.ListKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []doctorProblem
+	for _, name := range names {
+		keyProblems, err := checkKey(storage, ring, name)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, keyProblems...)
+	}
+
+	orphans, err := checkOrphans(ui, storage, ring, names)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, orphans...)
+
+	if fix {
+		confirmPrune(ui, problems, len(names))
+		for i := range problems {
+			if problems[i].fix == nil {
+				continue
+			}
+			problems[i].FixErr = problems[i].fix()
+			problems[i].Fixed = problems[i].FixErr == nil
+		}
+	}
+
+	return problems, nil
+}
+
+// confirmPrune asks before removing the keys without a secret when they are
+// most of them, as a wrong 2AMI_RING or 2AMI_BACKEND looks the same. Unless
+// confirmed, those keys are kept.
+func confirmPrune(ui cli.Ui, problems []doctorProblem, keys int) {
+	missing := 0
+	for _, p := range problems {
+		if p.Issue == issueMissingSecret {
+			missing++
+		}
+	}
+	if missing == 0 || missing*2 <= keys {
+		return
+	}
+
+	answer, err := ui.Ask(fmt.Sprintf("%d of %d keys have no secret, check 2AMI_RING and 2AMI_BACKEND are right. Remove them anyway? [yes/no]: ", missing, keys))
+	if err == nil && strings.ToLower(strings.TrimSpace(answer)) == "yes" {
+		return
+	}
+	for i := range problems {
+		if problems[i].Issue == issueMissingSecret {
+			problems[i].fix = nil
+			problems[i].FixErr = errors.New("not removed, most keys have no secret")
+		}
+	}
+}
+
+func checkKey(storage Storage, ring keyring.Keyring, name string) ([]doctorProblem, error) {
+	value, err := storage.GetKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []doctorProblem
+
+	item, err := ring.Get(name)
+	switch {
+	case errors.Is(err, keyring.ErrKeyNotFound):
+		// without a secret the key is of no use, nothing else to check
+		return []doctorProblem{{
+			Name:  name,
+			Issue: issueMissingSecret,
+			Fix:   "removed the key",
+			fix:   func() error { return storage.RemoveKey(name) },
+		}}, nil
+	case err != nil:
+		problems = append(problems, doctorProblem{Name: name, Issue: fmt.Sprintf("cannot read secret: %s", err)})
+	default:
+		if err := isValidBase32(string(item.Data)); err != nil {
+			problems = append(problems, doctorProblem{Name: name, Issue: fmt.Sprintf("secret is not base32: %s", err)})
+		}
+	}
+
+	key := Key{}
+	if err := json.Unmarshal(value, &key); err != nil {
+		return append(problems, doctorProblem{Name: name, Issue: fmt.Sprintf("unparseable record: %s", err)}), nil
+	}
+
+	if key.Digits == 0 {
+		digits := 6
+		if key.Type == STEAM_TOKEN {
+			digits = otp.SteamDigits
+		}
+		problems = append(problems, doctorProblem{
+			Name:  name,
+			Issue: "zero digits",
+			Fix:   fmt.Sprintf("set digits to %d", digits),
+			fix: func() error {
+				return updateKey(storage, name, func(k *Key) error {
+					k.Digits = digits
+					return nil
+				})
+			},
+		})
+	}
+	if key.Interval == 0 && key.Type != HOTP_TOKEN {
+		problems = append(problems, doctorProblem{
+			Name:  name,
+			Issue: "zero interval",
+			Fix:   "set interval to 30",
+			fix: func() error {
+				return updateKey(storage, name, func(k *Key) error {
+					k.Interval = 30
+					return nil
+				})
+			},
+		})
+	}
+
+	return problems, nil
+}
+
+// checkOrphans looks for 2ami items in the keyring without a key. Orphans
+// with a valid secret are re-linked to a new key with default settings, the
+// others are removed.
+func checkOrphans(ui cli.Ui, storage Storage, ring keyring.Keyring, names []string) ([]doctorProblem, error) {
+	keeps, err := keepsDescriptions(ring)
+	if err != nil {
+		return nil, err
+	}
+	if !keeps {
+		ui.Warn("This keyring does not keep item descriptions, orphaned secrets cannot be told from other applications' and are not checked")
+		return nil, nil
+	}
+
+	stored := make(map[string]bool, len(names))
+	for _, name := range names {
+		stored[name] = true
+	}
+
+	items, err := ring.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list keyring items: %w", err)
+	}
+
+	var problems []doctorProblem
+	for _, name := range items {
+		if stored[name] {
+			continue
+		}
+		item, err := ring.Get(name)
+		// the keyring may be shared with other applications
+		if err != nil || item.Description != secretDescription(name) {
+			continue
+		}
+
+		name := name
+		if isValidBase32(string(item.Data)) != nil {
+			problems = append(problems, doctorProblem{
+				Name:  name,
+				Issue: "orphaned keyring item with an invalid secret",
+				Fix:   "removed the keyring item",
+				fix:   func() error { return ring.Remove(name) },
+			})
+			continue
+		}
+		problems = append(problems, doctorProblem{
+			Name:  name,
+			Issue: "orphaned keyring item",
+			Fix:   "re-linked to a new totp key",
+			fix: func() error {
+				marshal, err := json.Marshal(NewKey(ring, name))
+				if err != nil {
+					return err
+				}
+				_, err = storage.AddKey(name, marshal)
+				return err
+			},
+		})
+	}
+
+	return problems, nil
+}
+
+// keepsDescriptions tells whether ring returns the description of its items,
+// which tells 2ami items from others. Backends like wincred and keyctl do not.
+func keepsDescriptions(ring keyring.Keyring) (bool, error) {
+	probe := probeItem()
+	if err := ring.Set(probe); err != nil {
+		return false, fmt.Errorf("cannot write to keyring: %w", err)
+	}
+	item, err := ring.Get(probe.Key)
+	removeErr := ring.Remove(probe.Key)
+	if err != nil {
+		return false, fmt.Errorf("cannot read keyring: %w", err)
+	}
+	if removeErr != nil {
+		return false, fmt.Errorf("cannot remove probe item from keyring: %w", removeErr)
+	}
+	return item.Description == probe.Description, nil
+}
+
+func printDoctorReport(ui cli.Ui, problems []doctorProblem, fix bool) {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	fixable := 0
+	for _, p := range problems {
+		switch {
+		case p.Fixed:
+			fmt.Fprintf(w, "%s\t%s\tfixed: %s\n", p.Name, p.Issue, p.Fix)
+		case p.FixErr != nil:
+			fmt.Fprintf(w, "%s\t%s\tcannot fix: %s\n", p.Name, p.Issue, p.FixErr)
+		case p.Fix != "" && !fix:
+			fixable++
+			fmt.Fprintf(w, "%s\t%s\tfixable\n", p.Name, p.Issue)
+		default:
+			fmt.Fprintf(w, "%s\t%s\n", p.Name, p.Issue)
+		}
+	}
+	_ = w.Flush()
+
+	if len(problems) == 0 {
+		ui.Output("No problem found")
+		return
+	}
+	ui.Output(strings.TrimRight(table.String(), "\n"))
+	if fixable > 0 {
+		ui.Output(fmt.Sprintf("%d problems found, %d can be fixed with --fix", len(problems), fixable))
+		return
+	}
+	ui.Output(fmt.Sprintf("%d problems found", len(problems)))
+}
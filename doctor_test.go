@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupDoctor stores a healthy key "ok" and returns the test storage and
+// keyring, for tests to break.
+func setupDoctor(t *testing.T) (Storage, keyring.Keyring) {
+	t.Helper()
+
+	storage, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+	ring := useTestKeyring(t)
+
+	require.NoError(t, add(storage, "ok", "JBSWY3DPEHPK3PXP", keyOptions{}))
+
+	return storage, ring
+}
+
+func issues(problems []doctorProblem) map[string]string {
+	found := make(map[string]string, len(problems))
+	for _, p := range problems {
+		found[p.Name] = p.Issue
+	}
+	return found
+}
+
+func TestDoctor_Healthy(t *testing.T) {
+	storage, _ := setupDoctor(t)
+
+	problems, err := doctor(cli.NewMockUi(), storage, false)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+
+	ui := cli.NewMockUi()
+	printDoctorReport(ui, problems, false)
+	assert.Equal(t, "No problem found\n", ui.OutputWriter.String())
+}
+
+func TestDoctor(t *testing.T) {
+	storage, ring := setupDoctor(t)
+
+	require.NoError(t, add(storage, "missing", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+	require.NoError(t, ring.Remove("missing"))
+
+	require.NoError(t, add(storage, "invalid", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+	require.NoError(t, ring.Set(keyring.Item{Key: "invalid", Data: []byte("not base32!"), Description: secretDescription("invalid")}))
+
+	require.NoError(t, add(storage, "zero", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+	require.NoError(t, updateKey(storage, "zero", func(k *Key) error {
+		k.Digits = 0
+		return nil
+	}))
+
+	_, err := storage.AddKey("broken", []byte("{"))
+	require.NoError(t, err)
+	require.NoError(t, ring.Set(keyring.Item{Key: "broken", Data: []byte("GEZDGNBVGY3TQOJQ")}))
+
+	require.NoError(t, ring.Set(keyring.Item{Key: "orphan", Data: []byte("MZXW6YTBOI"), Description: secretDescription("orphan")}))
+	require.NoError(t, ring.Set(keyring.Item{Key: "other-app", Data: []byte("password")}))
+
+	problems, err := doctor(cli.NewMockUi(), storage, false)
+	require.NoError(t, err)
+	found := issues(problems)
+	assert.Len(t, problems, 5)
+	assert.Equal(t, "missing secret", found["missing"])
+	assert.Contains(t, found["invalid"], "secret is not base32")
+	assert.Equal(t, "zero digits", found["zero"])
+	assert.Contains(t, found["broken"], "unparseable record")
+	assert.Equal(t, "orphaned keyring item", found["orphan"])
+
+	ui := cli.NewMockUi()
+	printDoctorReport(ui, problems, false)
+	assert.Contains(t, ui.OutputWriter.String(), "5 problems found, 3 can be fixed with --fix")
+
+	// nothing is changed without fix
+	_, err = storage.GetKey("missing")
+	require.NoError(t, err)
+}
+
+func TestDoctor_Fix(t *testing.T) {
+	storage, ring := setupDoctor(t)
+
+	require.NoError(t, add(storage, "missing", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+	require.NoError(t, ring.Remove("missing"))
+
+	require.NoError(t, add(storage, "steam", "GEZDGNBVGY3TQOJQ", keyOptions{Type: "steam"}))
+	require.NoError(t, updateKey(storage, "steam", func(k *Key) error {
+		k.Digits, k.Interval = 0, 0
+		return nil
+	}))
+
+	require.NoError(t, ring.Set(keyring.Item{Key: "orphan", Data: []byte("MZXW6YTBOI"), Description: secretDescription("orphan")}))
+	require.NoError(t, ring.Set(keyring.Item{Key: "garbage", Data: []byte("not base32!"), Description: secretDescription("garbage")}))
+
+	problems, err := doctor(cli.NewMockUi(), storage, true)
+	require.NoError(t, err)
+	require.Len(t, problems, 5)
+	for _, p := range problems {
+		assert.True(t, p.Fixed, "%s: %s", p.Name, p.Issue)
+	}
+
+	names, err := storage.ListKey()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ok", "steam", "orphan"}, names)
+
+	value, err := storage.GetKey("steam")
+	require.NoError(t, err)
+	key := Key{}
+	require.NoError(t, json.Unmarshal(value, &key))
+	assert.Equal(t, 5, key.Digits)
+	assert.Equal(t, 30, key.Interval)
+
+	_, err = ring.Get("garbage")
+	assert.ErrorIs(t, err, keyring.ErrKeyNotFound)
+
+	problems, err = doctor(cli.NewMockUi(), storage, false)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+}
+
+func TestDoctor_FixMostMissing(t *testing.T) {
+	for _, tt := range []struct {
+		answer    string
+		wantNames []string
+	}{
+		{"no\n", []string{"ok", "one", "two"}},
+		{"yes\n", []string{"ok"}},
+	} {
+		t.Run(strings.TrimSpace(tt.answer), func(t *testing.T) {
+			storage, ring := setupDoctor(t)
+			for _, name := range []string{"one", "two"} {
+				require.NoError(t, add(storage, name, "GEZDGNBVGY3TQOJQ", keyOptions{}))
+				require.NoError(t, ring.Remove(name))
+			}
+
+			ui := cli.NewMockUi()
+			ui.InputReader = iotest.OneByteReader(strings.NewReader(tt.answer))
+			problems, err := doctor(ui, storage, true)
+			require.NoError(t, err)
+			require.Len(t, problems, 2)
+			assert.Contains(t, ui.OutputWriter.String(), "2 of 3 keys have no secret")
+
+			names, err := storage.ListKey()
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.wantNames, names)
+		})
+	}
+}
+
+// descriptionlessKeyring drops item descriptions, as the wincred and keyctl
+// backends do.
+type descriptionlessKeyring struct {
+	keyring.Keyring
+}
+
+func (r descriptionlessKeyring) Get(key string) (keyring.Item, error) {
+	item, err := r.Keyring.Get(key)
+	item.Description = ""
+	return item, err
+}
+
+func TestDoctor_NoDescriptions(t *testing.T) {
+	storage, ring := setupDoctor(t)
+	require.NoError(t, ring.Set(keyring.Item{Key: "orphan", Data: []byte("MZXW6YTBOI"), Description: secretDescription("orphan")}))
+	openKeyring = func() (keyring.Keyring, error) { return descriptionlessKeyring{ring}, nil }
+
+	ui := cli.NewMockUi()
+	problems, err := doctor(ui, storage, true)
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	assert.Contains(t, ui.ErrorWriter.String(), "orphaned secrets cannot be told")
+
+	// the orphan is left alone and the probe is gone
+	keys, err := ring.Keys()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ok", "orphan"}, keys)
+}
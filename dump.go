@@ -5,13 +5,24 @@ import (
 	"fmt"
 )
 
+// dumpedKey is the dump representation of a Key, with the type spelled out
+// instead of its stored numeric value.
+type dumpedKey struct {
+	Key
+	Type string `json:"type"`
+}
+
+func newDumpedKey(key Key) dumpedKey {
+	return dumpedKey{Key: key, Type: key.Type.String()}
+}
+
 func dumpAllKeys(storage Storage) (errors []error) {
 	keys, err := storage.ListKey()
 	if err != nil {
 		return []error{err}
 	}
 
-	allKeys := []Key{}
+	allKeys := []dumpedKey{}
 
 	for _, v := range keys {
 		value, err := storage.GetKey(v)
@@ -25,7 +36,7 @@ func dumpAllKeys(storage Storage) (errors []error) {
 		}
 		debugPrint(fmt.Sprintf("%#v", key))
 
-		allKeys = append(allKeys, key)
+		allKeys = append(allKeys, newDumpedKey(key))
 	}
 
 	if len(errors) > 0 {
@@ -51,7 +62,7 @@ func dumpKey(storage Storage, name string) (err error) {
 	}
 	debugPrint(fmt.Sprintf("%#v", key))
 
-	marshaledKey, _ := json.Marshal(key)
+	marshaledKey, _ := json.Marshal(newDumpedKey(key))
 	fmt.Println(string(marshaledKey))
 
 	return nil
@@ -2,7 +2,8 @@
 //
 // Aegis Authenticator is a free, secure and open source 2FA app for Android that supports
 // HOTP and TOTP algorithms. This package implements the Aegis backup format specification
-// to allow importing 2FA secrets from Aegis backups into other applications.
+// to allow importing 2FA secrets from Aegis backups into other applications, and
+// exporting them as encrypted Aegis backups with EncryptBackup.
 //
 // # Supported Backup Formats
 //
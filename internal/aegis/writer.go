@@ -0,0 +1,149 @@
+package aegis
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// BackupVersion is the version of the backups written by EncryptBackup.
+	BackupVersion = 1
+	// DBVersion is the version of the databases written by EncryptBackup.
+	DBVersion = 3
+
+	// PasswordSlot is the type of the slots unlocked with a password.
+	PasswordSlot = 1
+)
+
+// Scrypt parameters used by Aegis for password slots.
+const (
+	ScryptN = 32768
+	ScryptR = 8
+	ScryptP = 1
+)
+
+const (
+	keySize  = 32
+	saltSize = 32
+)
+
+// EncryptBackup returns an encrypted backup of db, with a password slot
+// unlocked by password.
+//
+// As Aegis does, the database is encrypted with a random master key, which is
+// stored in the slot encrypted with a key derived from the password.
+func EncryptBackup(db *DB, password string) (*Backup, error) {
+	masterKey, err := randomBytes(keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	slot, err := newPasswordSlot(masterKey, password)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := json.Marshal(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal database: %w", err)
+	}
+	ciphertext, params, err := encrypt(masterKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt database: %w", err)
+	}
+
+	return &Backup{
+		Version: BackupVersion,
+		Header: Header{
+			Slots:  []Slot{slot},
+			Params: params,
+		},
+		DB: base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// NewUUID returns a random (version 4) UUID, as used to identify entries,
+// groups and slots.
+func NewUUID() (string, error) {
+	u, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// newPasswordSlot returns a slot holding masterKey, encrypted with a key
+// derived from password.
+func newPasswordSlot(masterKey []byte, password string) (Slot, error) {
+	salt, err := randomBytes(saltSize)
+	if err != nil {
+		return Slot{}, err
+	}
+	uuid, err := NewUUID()
+	if err != nil {
+		return Slot{}, err
+	}
+
+	key, err := deriveKey(password, hex.EncodeToString(salt), ScryptN, ScryptR, ScryptP)
+	if err != nil {
+		return Slot{}, fmt.Errorf("failed to derive key: %w", err)
+	}
+	encryptedKey, params, err := encrypt(key, masterKey)
+	if err != nil {
+		return Slot{}, fmt.Errorf("failed to encrypt master key: %w", err)
+	}
+
+	return Slot{
+		Type:      PasswordSlot,
+		UUID:      uuid,
+		Key:       hex.EncodeToString(encryptedKey),
+		KeyParams: params,
+		N:         ScryptN,
+		R:         ScryptR,
+		P:         ScryptP,
+		Salt:      hex.EncodeToString(salt),
+	}, nil
+}
+
+// encrypt seals plaintext using AES-GCM, returning the ciphertext without
+// the tag, which is stored in params with the nonce.
+func encrypt(key []byte, plaintext []byte) ([]byte, Params, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, Params{}, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, Params{}, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, Params{}, err
+	}
+
+	sealed := gcm.Seal(nil, nonce, plaintext, nil)
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return ciphertext, Params{
+		Nonce: hex.EncodeToString(nonce),
+		Tag:   hex.EncodeToString(tag),
+	}, nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return b, nil
+}
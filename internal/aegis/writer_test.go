@@ -0,0 +1,94 @@
+package aegis
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestEncryptBackupRoundTrip(t *testing.T) {
+	data, err := os.ReadFile("testdata/aegis_plain.json")
+	if err != nil {
+		t.Fatalf("Failed to read test file: %v", err)
+	}
+	plain, err := ParseBackup(data)
+	if err != nil {
+		t.Fatalf("Failed to parse plain backup: %v", err)
+	}
+	db, err := plain.ParsePlainBackup()
+	if err != nil {
+		t.Fatalf("Failed to parse plain backup: %v", err)
+	}
+
+	encrypted, err := EncryptBackup(db, "test")
+	if err != nil {
+		t.Fatalf("Failed to encrypt backup: %v", err)
+	}
+
+	// Go through JSON, as when the backup is written to a file
+	encoded, err := json.Marshal(encrypted)
+	if err != nil {
+		t.Fatalf("Failed to marshal backup: %v", err)
+	}
+	backup, err := ParseBackup(encoded)
+	if err != nil {
+		t.Fatalf("Failed to parse encrypted backup: %v", err)
+	}
+
+	if backup.Version != BackupVersion {
+		t.Errorf("Expected version %d, got %d", BackupVersion, backup.Version)
+	}
+	if !backup.IsEncrypted() {
+		t.Fatal("Expected backup to be encrypted")
+	}
+	slot := backup.Header.Slots[0]
+	if slot.Type != PasswordSlot || slot.N != ScryptN || slot.R != ScryptR || slot.P != ScryptP {
+		t.Errorf("Unexpected password slot: %+v", slot)
+	}
+
+	decrypted, err := backup.DecryptBackup("test")
+	if err != nil {
+		t.Fatalf("Failed to decrypt backup: %v", err)
+	}
+	if !reflect.DeepEqual(decrypted, db) {
+		t.Errorf("Decrypted database differs:\nexpected %+v\ngot      %+v", db, decrypted)
+	}
+
+	if _, err := backup.DecryptBackup("wrongpassword"); err == nil {
+		t.Error("Expected error when using wrong password")
+	}
+}
+
+func TestEncryptBackupRandomKeys(t *testing.T) {
+	db := &DB{Version: DBVersion}
+
+	first, err := EncryptBackup(db, "test")
+	if err != nil {
+		t.Fatalf("Failed to encrypt backup: %v", err)
+	}
+	second, err := EncryptBackup(db, "test")
+	if err != nil {
+		t.Fatalf("Failed to encrypt backup: %v", err)
+	}
+
+	if first.Header.Slots[0].Salt == second.Header.Slots[0].Salt {
+		t.Error("Expected a random salt")
+	}
+	if first.Header.Params.Nonce == second.Header.Params.Nonce {
+		t.Error("Expected a random nonce")
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	uuid, err := NewUUID()
+	if err != nil {
+		t.Fatalf("Failed to generate UUID: %v", err)
+	}
+
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !pattern.MatchString(uuid) {
+		t.Errorf("Invalid UUID: %s", uuid)
+	}
+}
@@ -0,0 +1,304 @@
+// Package migration encodes and decodes the account transfer payloads used by
+// Google Authenticator.
+//
+// When exporting accounts, Google Authenticator shows one or more QR codes
+// encoding URIs in the form:
+//
+//	otpauth-migration://offline?data=<base64 protobuf MigrationPayload>
+//
+// The protobuf schema is not published, this package implements the one
+// commonly reverse engineered from the application:
+//
+//	message MigrationPayload {
+//	    repeated OtpParameters otp_parameters = 1;
+//	    int32 version = 2;
+//	    int32 batch_size = 3;
+//	    int32 batch_index = 4;
+//	    int32 batch_id = 5;
+//	}
+//
+//	message OtpParameters {
+//	    bytes secret = 1;
+//	    string name = 2;
+//	    string issuer = 3;
+//	    Algorithm algorithm = 4;
+//	    DigitCount digits = 5;
+//	    OtpType type = 6;
+//	    int64 counter = 7;
+//	}
+//
+// Messages are encoded and decoded with the low level protowire package, so
+// no generated code is required.
+//
+// # Usage Example
+//
+//	payload, err := migration.ParseURI("otpauth-migration://offline?data=...")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	for _, p := range payload.OtpParameters {
+//	    fmt.Printf("Entry: %s (%s) - %d digits\n", p.Name, p.Issuer, p.Digits.Count())
+//	}
+package migration
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Scheme is the URI scheme of Google Authenticator migration URIs.
+const Scheme = "otpauth-migration"
+
+// Version is the payload version written by Marshal.
+const Version = 1
+
+// Algorithm is the hash algorithm of an entry.
+type Algorithm int32
+
+const (
+	AlgorithmUnspecified Algorithm = 0
+	AlgorithmSHA1        Algorithm = 1
+	AlgorithmSHA256      Algorithm = 2
+	AlgorithmSHA512      Algorithm = 3
+	AlgorithmMD5         Algorithm = 4
+)
+
+// DigitCount is the token length of an entry.
+type DigitCount int32
+
+const (
+	DigitCountUnspecified DigitCount = 0
+	DigitCountSix         DigitCount = 1
+	DigitCountEight       DigitCount = 2
+)
+
+// Count returns the number of digits, 6 when unspecified.
+func (d DigitCount) Count() int {
+	if d == DigitCountEight {
+		return 8
+	}
+	return 6
+}
+
+// OtpType is the type of an entry.
+type OtpType int32
+
+const (
+	OtpTypeUnspecified OtpType = 0
+	OtpTypeHOTP        OtpType = 1
+	OtpTypeTOTP        OtpType = 2
+)
+
+// OtpParameters is a single exported account.
+type OtpParameters struct {
+	// Secret is the raw secret, not base32 encoded.
+	Secret    []byte
+	Name      string
+	Issuer    string
+	Algorithm Algorithm
+	Digits    DigitCount
+	Type      OtpType
+	Counter   int64
+}
+
+// Payload is the content of a migration URI. Large exports are split in
+// batches sharing the same BatchID.
+type Payload struct {
+	OtpParameters []OtpParameters
+	Version       int32
+	BatchSize     int32
+	BatchIndex    int32
+	BatchID       int32
+}
+
+// ParseURI decodes an otpauth-migration:// URI.
+func ParseURI(uri string) (*Payload, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse migration URI: %w", err)
+	}
+	if u.Scheme != Scheme {
+		return nil, fmt.Errorf("unsupported URI scheme: %s", u.Scheme)
+	}
+
+	data := u.Query().Get("data")
+	if data == "" {
+		return nil, fmt.Errorf("migration URI has no data")
+	}
+
+	// an unescaped + in the query is decoded as a space
+	data = strings.ReplaceAll(data, " ", "+")
+	// the payload is standard base64, but some tools emit it unpadded
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(data, "="))
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration data: %w", err)
+		}
+	}
+
+	return Unmarshal(decoded)
+}
+
+// Unmarshal decodes a protobuf MigrationPayload.
+func Unmarshal(data []byte) (*Payload, error) {
+	var payload Payload
+	err := walk(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			params, err := unmarshalOtpParameters(value)
+			if err != nil {
+				return err
+			}
+			payload.OtpParameters = append(payload.OtpParameters, params)
+		case num == 2 && typ == protowire.VarintType:
+			payload.Version = int32(varint)
+		case num == 3 && typ == protowire.VarintType:
+			payload.BatchSize = int32(varint)
+		case num == 4 && typ == protowire.VarintType:
+			payload.BatchIndex = int32(varint)
+		case num == 5 && typ == protowire.VarintType:
+			payload.BatchID = int32(varint)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse migration payload: %w", err)
+	}
+	return &payload, nil
+}
+
+func unmarshalOtpParameters(data []byte) (OtpParameters, error) {
+	var params OtpParameters
+	err := walk(data, func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			params.Secret = append([]byte(nil), value...)
+		case num == 2 && typ == protowire.BytesType:
+			params.Name = string(value)
+		case num == 3 && typ == protowire.BytesType:
+			params.Issuer = string(value)
+		case num == 4 && typ == protowire.VarintType:
+			params.Algorithm = Algorithm(varint)
+		case num == 5 && typ == protowire.VarintType:
+			params.Digits = DigitCount(varint)
+		case num == 6 && typ == protowire.VarintType:
+			params.Type = OtpType(varint)
+		case num == 7 && typ == protowire.VarintType:
+			params.Counter = int64(varint)
+		}
+		return nil
+	})
+	if err != nil {
+		return OtpParameters{}, fmt.Errorf("invalid otp parameters: %w", err)
+	}
+	return params, nil
+}
+
+// URI returns the otpauth-migration:// URI of the payload.
+func (p *Payload) URI() string {
+	u := url.URL{
+		Scheme:   Scheme,
+		Host:     "offline",
+		RawQuery: url.Values{"data": {base64.StdEncoding.EncodeToString(p.Marshal())}}.Encode(),
+	}
+	return u.String()
+}
+
+// Marshal encodes the payload as a protobuf MigrationPayload.
+func (p *Payload) Marshal() []byte {
+	var b []byte
+	for _, params := range p.OtpParameters {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, params.marshal())
+	}
+	b = appendVarint(b, 2, uint64(p.Version))
+	b = appendVarint(b, 3, uint64(p.BatchSize))
+	b = appendVarint(b, 4, uint64(p.BatchIndex))
+	b = appendVarint(b, 5, uint64(p.BatchID))
+	return b
+}
+
+func (p OtpParameters) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, p.Secret)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, p.Name)
+	if p.Issuer != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, p.Issuer)
+	}
+	b = appendVarint(b, 4, uint64(p.Algorithm))
+	b = appendVarint(b, 5, uint64(p.Digits))
+	b = appendVarint(b, 6, uint64(p.Type))
+	b = appendVarint(b, 7, uint64(p.Counter))
+	return b
+}
+
+// appendVarint appends a varint field, omitting zero values as proto3 does.
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// Batches splits entries in payloads of at most size entries each, sharing
+// batchID, as Google Authenticator does to keep QR codes readable.
+func Batches(entries []OtpParameters, size int, batchID int32) []*Payload {
+	count := (len(entries) + size - 1) / size
+	payloads := make([]*Payload, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(entries) {
+			end = len(entries)
+		}
+		payloads = append(payloads, &Payload{
+			OtpParameters: entries[i*size : end],
+			Version:       Version,
+			BatchSize:     int32(count),
+			BatchIndex:    int32(i),
+			BatchID:       batchID,
+		})
+	}
+	return payloads
+}
+
+// walk calls fn for each field in a protobuf message. Length delimited
+// values are passed in value, varints in varint; other types are skipped.
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte, varint uint64) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		var value []byte
+		var varint uint64
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			varint, n = protowire.ConsumeVarint(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, typ, value, varint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package migration
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// exampleURI is the export of a single TOTP account, secret JBSWY3DPEHPK3PXP.
+const exampleURI = "otpauth-migration://offline?data=CjEKCkhlbGxvId6tvu8SGEV4YW1wbGU6YWxpY2VAZ29vZ2xlLmNvbRoHRXhhbXBsZTAC"
+
+func TestParseURI(t *testing.T) {
+	payload, err := ParseURI(exampleURI)
+	if err != nil {
+		t.Fatalf("Failed to parse migration URI: %v", err)
+	}
+
+	if len(payload.OtpParameters) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(payload.OtpParameters))
+	}
+
+	params := payload.OtpParameters[0]
+	if !bytes.Equal(params.Secret, []byte("Hello!\xde\xad\xbe\xef")) {
+		t.Errorf("Unexpected secret %x", params.Secret)
+	}
+	if params.Name != "Example:alice@google.com" {
+		t.Errorf("Expected name 'Example:alice@google.com', got '%s'", params.Name)
+	}
+	if params.Issuer != "Example" {
+		t.Errorf("Expected issuer 'Example', got '%s'", params.Issuer)
+	}
+	if params.Type != OtpTypeTOTP {
+		t.Errorf("Expected type TOTP, got %d", params.Type)
+	}
+	if params.Digits.Count() != 6 {
+		t.Errorf("Expected 6 digits, got %d", params.Digits.Count())
+	}
+}
+
+func TestParseURIInvalid(t *testing.T) {
+	invalid := []string{
+		"otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP",
+		"otpauth-migration://offline",
+		"otpauth-migration://offline?data=not-base64!",
+		"otpauth-migration://offline?data=CjEK",
+	}
+	for _, uri := range invalid {
+		if _, err := ParseURI(uri); err == nil {
+			t.Errorf("Expected error parsing %s", uri)
+		}
+	}
+}
+
+func TestDigitCount(t *testing.T) {
+	if DigitCountUnspecified.Count() != 6 {
+		t.Error("Unspecified digit count should be 6")
+	}
+	if DigitCountSix.Count() != 6 {
+		t.Error("Digit count six should be 6")
+	}
+	if DigitCountEight.Count() != 8 {
+		t.Error("Digit count eight should be 8")
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	payload := &Payload{
+		OtpParameters: []OtpParameters{
+			{
+				Secret:    []byte("Hello!\xde\xad\xbe\xef"),
+				Name:      "Example:alice@google.com",
+				Issuer:    "Example",
+				Algorithm: AlgorithmSHA1,
+				Digits:    DigitCountSix,
+				Type:      OtpTypeTOTP,
+			},
+			{
+				Secret:    []byte{0x01, 0x02},
+				Name:      "bob",
+				Algorithm: AlgorithmSHA512,
+				Digits:    DigitCountEight,
+				Type:      OtpTypeHOTP,
+				Counter:   42,
+			},
+		},
+		Version:    Version,
+		BatchSize:  1,
+		BatchIndex: 0,
+		BatchID:    -12345,
+	}
+
+	decoded, err := ParseURI(payload.URI())
+	if err != nil {
+		t.Fatalf("Failed to parse generated URI: %v", err)
+	}
+	if !reflect.DeepEqual(payload, decoded) {
+		t.Errorf("Round trip mismatch:\nexpected %+v\ngot      %+v", payload, decoded)
+	}
+}
+
+func TestBatches(t *testing.T) {
+	entries := make([]OtpParameters, 25)
+	payloads := Batches(entries, 10, 7)
+
+	if len(payloads) != 3 {
+		t.Fatalf("Expected 3 batches, got %d", len(payloads))
+	}
+	sizes := []int{10, 10, 5}
+	for i, payload := range payloads {
+		if len(payload.OtpParameters) != sizes[i] {
+			t.Errorf("Batch %d: expected %d entries, got %d", i, sizes[i], len(payload.OtpParameters))
+		}
+		if payload.BatchIndex != int32(i) || payload.BatchSize != 3 || payload.BatchID != 7 {
+			t.Errorf("Batch %d: unexpected batch fields %+v", i, payload)
+		}
+	}
+
+	if len(Batches(nil, 10, 7)) != 0 {
+		t.Error("Expected no batches without entries")
+	}
+}
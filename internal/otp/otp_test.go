@@ -0,0 +1,233 @@
+package otp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHOTP_RFC4226(t *testing.T) {
+	// Test values from RFC 4226 Appendix D.
+	secret := []byte("12345678901234567890")
+	expected := []string{
+		"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489",
+	}
+
+	params := Params{Secret: secret, Digits: 6, Algorithm: SHA1}
+	for counter, want := range expected {
+		got, err := HOTP(params, uint64(counter))
+		if err != nil {
+			t.Fatalf("HOTP(%d) failed: %v", counter, err)
+		}
+		if got != want {
+			t.Errorf("HOTP(%d): expected %s, got %s", counter, want, got)
+		}
+	}
+}
+
+func TestTOTP_RFC6238(t *testing.T) {
+	// Seeds and test values from RFC 6238 Appendix B.
+	seeds := map[Algorithm][]byte{
+		SHA1:   []byte("12345678901234567890"),
+		SHA256: []byte("12345678901234567890123456789012"),
+		SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+	}
+	testCases := []struct {
+		time      int64
+		algorithm Algorithm
+		want      string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{1111111111, SHA1, "14050471"},
+		{1111111111, SHA256, "67062674"},
+		{1111111111, SHA512, "99943326"},
+		{1234567890, SHA1, "89005924"},
+		{1234567890, SHA256, "91819424"},
+		{1234567890, SHA512, "93441116"},
+		{2000000000, SHA1, "69279037"},
+		{2000000000, SHA256, "90698825"},
+		{2000000000, SHA512, "38618901"},
+		{20000000000, SHA1, "65353130"},
+		{20000000000, SHA256, "77737706"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%d", tc.algorithm, tc.time), func(t *testing.T) {
+			params := Params{Secret: seeds[tc.algorithm], Digits: 8, Algorithm: tc.algorithm}
+			got, err := TOTP(params, time.Unix(tc.time, 0), 30, 0)
+			if err != nil {
+				t.Fatalf("TOTP failed: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("Expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestTOTP_LongPeriodAndT0(t *testing.T) {
+	params := Params{Secret: []byte("12345678901234567890"), Digits: 6, Algorithm: SHA1}
+
+	// A 300 seconds period must not wrap around: t=600 is time step 2.
+	got, err := TOTP(params, time.Unix(600, 0), 300, 0)
+	if err != nil {
+		t.Fatalf("TOTP failed: %v", err)
+	}
+	want, _ := HOTP(params, 2)
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+
+	// With t0=1000, t=1059 is time step 1.
+	got, err = TOTP(params, time.Unix(1059, 0), 30, 1000)
+	if err != nil {
+		t.Fatalf("TOTP failed: %v", err)
+	}
+	want, _ = HOTP(params, 1)
+	if got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestHOTP_Digits(t *testing.T) {
+	params := Params{Secret: []byte("12345678901234567890"), Algorithm: SHA1}
+
+	// Counter 0 truncates to 1284755224, see RFC 4226 Appendix D.
+	for digits, want := range map[int]string{6: "755224", 8: "84755224", 10: "1284755224"} {
+		params.Digits = digits
+		got, err := HOTP(params, 0)
+		if err != nil {
+			t.Fatalf("HOTP with %d digits failed: %v", digits, err)
+		}
+		if got != want {
+			t.Errorf("HOTP with %d digits: expected %s, got %s", digits, want, got)
+		}
+	}
+
+	for _, digits := range []int{0, 5, 11} {
+		params.Digits = digits
+		if _, err := HOTP(params, 0); err == nil {
+			t.Errorf("Expected error with %d digits", digits)
+		}
+	}
+}
+
+func TestHOTP_CustomEncoder(t *testing.T) {
+	params := Params{
+		Secret:    []byte("12345678901234567890"),
+		Digits:    3,
+		Algorithm: SHA1,
+		Encoder: func(code uint32, digits int) string {
+			return fmt.Sprintf("%d:%d", digits, code)
+		},
+	}
+	got, err := HOTP(params, 0)
+	if err != nil {
+		t.Fatalf("HOTP failed: %v", err)
+	}
+	if want := "3:1284755224"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestTimeStepAndRemaining(t *testing.T) {
+	testCases := []struct {
+		time, period, t0 int64
+		step             uint64
+		remaining        int64
+	}{
+		{59, 30, 0, 1, 1},
+		{100, 45, 0, 2, 35},
+		{100, 90, 0, 1, 80},
+		{59, 30, 10, 1, 11},
+	}
+	for _, tc := range testCases {
+		at := time.Unix(tc.time, 0)
+		step, err := TimeStep(at, tc.period, tc.t0)
+		if err != nil {
+			t.Fatalf("TimeStep failed: %v", err)
+		}
+		if step != tc.step {
+			t.Errorf("TimeStep(%d, %d, %d): expected %d, got %d", tc.time, tc.period, tc.t0, tc.step, step)
+		}
+		remaining, err := Remaining(at, tc.period, tc.t0)
+		if err != nil {
+			t.Fatalf("Remaining failed: %v", err)
+		}
+		if remaining != tc.remaining {
+			t.Errorf("Remaining(%d, %d, %d): expected %d, got %d", tc.time, tc.period, tc.t0, tc.remaining, remaining)
+		}
+	}
+
+	if _, err := TimeStep(time.Unix(10, 0), 0, 0); err == nil {
+		t.Error("Expected error with zero period")
+	}
+	if _, err := TimeStep(time.Unix(10, 0), 30, 20); err == nil {
+		t.Error("Expected error with time before t0")
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Algorithm
+		wantErr bool
+	}{
+		{"SHA1", SHA1, false},
+		{"sha256", SHA256, false},
+		{"SHA-512", SHA512, false},
+		{"MD5", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAlgorithm(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseAlgorithm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAlgorithm() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	for _, secret := range []string{"ORSXG5A=", "ORSXG5A", "orsxg5a"} {
+		got, err := DecodeSecret(secret)
+		if err != nil {
+			t.Fatalf("DecodeSecret(%q) failed: %v", secret, err)
+		}
+		if string(got) != "test" {
+			t.Errorf("DecodeSecret(%q): expected test, got %q", secret, got)
+		}
+	}
+
+	if _, err := DecodeSecret("*^ASD"); err == nil {
+		t.Error("Expected error with invalid secret")
+	}
+}
+
+func TestSteam(t *testing.T) {
+	testCases := []struct {
+		code uint32
+		want string
+	}{
+		{0, "22222"},
+		{1, "32222"},
+		{25, "Y2222"},
+		{26, "23222"},
+		{1284755224, "GG5F5"},
+	}
+	for _, tc := range testCases {
+		if got := Steam(tc.code, SteamDigits); got != tc.want {
+			t.Errorf("Steam(%d): expected %s, got %s", tc.code, tc.want, got)
+		}
+	}
+}
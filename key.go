@@ -8,36 +8,85 @@ import (
 	"fmt"
 	"log"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/99designs/keyring"
-	otp "github.com/hgfischer/go-otp"
+
+	"github.com/endorama/2ami/internal/otp"
 )
 
 type KeyType int8
 
 const (
-	HOTP_TOKEN KeyType = 0
-	TOTP_TOKEN KeyType = 1
+	HOTP_TOKEN  KeyType = 0
+	TOTP_TOKEN  KeyType = 1
+	STEAM_TOKEN KeyType = 2
+)
+
+// parseKeyType returns the KeyType matching name, ignoring case.
+func parseKeyType(name string) (KeyType, error) {
+	switch strings.ToLower(name) {
+	case "totp":
+		return TOTP_TOKEN, nil
+	case "hotp":
+		return HOTP_TOKEN, nil
+	case "steam":
+		return STEAM_TOKEN, nil
+	default:
+		return 0, fmt.Errorf("unsupported key type: %s", name)
+	}
+}
+
+func (t KeyType) String() string {
+	switch t {
+	case TOTP_TOKEN:
+		return "totp"
+	case HOTP_TOKEN:
+		return "hotp"
+	case STEAM_TOKEN:
+		return "steam"
+	default:
+		return fmt.Sprintf("unknown(%d)", int8(t))
+	}
+}
+
+// Algorithm is the HMAC hash function used to generate tokens.
+type Algorithm = otp.Algorithm
+
+const (
+	SHA1_ALGORITHM   = otp.SHA1
+	SHA256_ALGORITHM = otp.SHA256
+	SHA512_ALGORITHM = otp.SHA512
 )
 
 type Key struct {
-	Name     string  `json:"name"`
-	Type     KeyType `json:"type,int8"`    //nolint
-	Digits   int     `json:"digits,int"`   //nolint
-	Interval int     `json:"interval,int"` //nolint
-	Counter  int     `json:"counter,int"`  //nolint
+	Name      string    `json:"name"`
+	Type      KeyType   `json:"type,int8"`    //nolint
+	Digits    int       `json:"digits,int"`   //nolint
+	Interval  int       `json:"interval,int"` //nolint
+	Counter   int       `json:"counter,int"`  //nolint
+	Algorithm Algorithm `json:"algorithm,omitempty"`
+	// T0 is the Unix time, in seconds, from which TOTP time steps are counted.
+	T0      int64  `json:"t0,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+	Account string `json:"account,omitempty"`
+	// Tags group keys, as Aegis groups do.
+	Tags     []string `json:"tags,omitempty"`
+	Note     string   `json:"note,omitempty"`
+	Favorite bool     `json:"favorite,omitempty"`
 	secret   SecretString
 }
 
 func NewKey(ring keyring.Keyring, name string) Key {
 	return Key{
-		Name:     name,
-		Type:     TOTP_TOKEN,
-		Digits:   6,
-		Interval: 30,
-		Counter:  1,
-		secret:   newSecretString(name, ring),
+		Name:      name,
+		Type:      TOTP_TOKEN,
+		Digits:    6,
+		Interval:  30,
+		Counter:   1,
+		Algorithm: SHA1_ALGORITHM,
+		secret:    newSecretString(name, ring),
 	}
 }
 
@@ -60,7 +109,10 @@ func (k Key) String() string {
 }
 
 func (k Key) VerboseString() string {
-	return fmt.Sprintf("%s \t %d digits every %d seconds", k.Name, k.Digits, k.Interval)
+	if k.Type == HOTP_TOKEN {
+		return fmt.Sprintf("%s \t %s %d digits at counter %d", k.Name, k.Type, k.Digits, k.Counter)
+	}
+	return fmt.Sprintf("%s \t %s %d digits every %d seconds", k.Name, k.Type, k.Digits, k.Interval)
 }
 
 func (k *Key) GenerateToken() string {
@@ -69,48 +121,94 @@ func (k *Key) GenerateToken() string {
 		return k.totpToken()
 	case HOTP_TOKEN:
 		return k.hotpToken()
+	case STEAM_TOKEN:
+		return k.totpToken()
 	default:
-		panic("Unknown key type. Valid type: TOTP or HOTP")
+		panic("Unknown key type. Valid type: TOTP, HOTP or Steam")
 	}
 }
 
+// ExpiresIn returns the seconds left before the current TOTP token expires.
 func (k *Key) ExpiresIn() int {
-	currentTime := time.Now()
-	return k.Interval - (currentTime.Second() % k.Interval)
+	return k.expiresInAt(time.Now())
+}
+
+func (k *Key) expiresInAt(t time.Time) int {
+	remaining, err := otp.Remaining(t, int64(k.Interval), k.T0)
+	if err != nil {
+		debugPrint(fmt.Sprintf("cannot compute expiration for %s: %s", k.Name, err))
+		return 0
+	}
+	return int(remaining)
+}
+
+// TimeStep returns the index of the current TOTP time step, the moving factor
+// used to generate the token.
+func (k *Key) TimeStep() uint64 {
+	return k.timeStepAt(time.Now())
+}
+
+func (k *Key) timeStepAt(t time.Time) uint64 {
+	step, err := otp.TimeStep(t, int64(k.Interval), k.T0)
+	if err != nil {
+		debugPrint(fmt.Sprintf("cannot compute time step for %s: %s", k.Name, err))
+		return 0
+	}
+	return step
 }
 
 func (k *Key) totpToken() string {
-	secret, err := k.secret.Value()
+	return k.totpTokenAt(time.Now())
+}
+
+func (k *Key) totpTokenAt(t time.Time) string {
+	params, err := k.otpParams()
 	if err != nil {
 		log.Fatal(err)
 	}
-	totp := &otp.TOTP{
-		Secret:         string(secret),
-		Length:         uint8(k.Digits),
-		Period:         uint8(k.Interval),
-		IsBase32Secret: true,
+	token, err := otp.TOTP(params, t, int64(k.Interval), k.T0)
+	if err != nil {
+		log.Fatal(err)
 	}
-	token := totp.Get()
 	return token
 }
 
 // Generate a new HOTP token and increament counter
 func (k *Key) hotpToken() string {
-	secret, err := k.secret.Value()
+	params, err := k.otpParams()
 	if err != nil {
 		log.Fatal(err)
 	}
-	hotp := &otp.HOTP{
-		Secret:         string(secret),
-		Counter:        uint64(k.Counter),
-		Length:         uint8(k.Digits),
-		IsBase32Secret: true,
+	token, err := otp.HOTP(params, uint64(k.Counter))
+	if err != nil {
+		log.Fatal(err)
 	}
-	token := hotp.Get()
 	k.Counter++
 	return token
 }
 
+// otpParams reads the secret from the keyring and returns the parameters to
+// generate a token for the key.
+func (k *Key) otpParams() (otp.Params, error) {
+	value, err := k.secret.Value()
+	if err != nil {
+		return otp.Params{}, err
+	}
+	secret, err := otp.DecodeSecret(string(value))
+	if err != nil {
+		return otp.Params{}, err
+	}
+	params := otp.Params{
+		Secret:    secret,
+		Digits:    k.Digits,
+		Algorithm: k.Algorithm,
+	}
+	if k.Type == STEAM_TOKEN {
+		params.Encoder = otp.Steam
+	}
+	return params, nil
+}
+
 func (k *Key) Secret(secret string) error {
 	return k.secret.Set([]byte(secret))
 }
@@ -128,10 +226,14 @@ func (k *Key) Rename(newName string) error {
 	return nil
 }
 
+// OtpauthURI returns the key as an otpauth:// URI, including its secret.
 func (k Key) OtpauthURI() (string, error) {
+	label := k.otpauthLabel()
 	out := url.URL{
 		Scheme: "otpauth",
-		Path:   k.Name,
+		Path:   "/" + label,
+		// a slash in the label must not become a path separator
+		RawPath: "/" + url.PathEscape(label),
 	}
 	q := out.Query()
 	secret, err := k.secret.Value()
@@ -140,17 +242,59 @@ func (k Key) OtpauthURI() (string, error) {
 	}
 	q.Set("secret", string(secret))
 	q.Set("digits", fmt.Sprint(k.Digits))
+	if k.Issuer != "" {
+		q.Set("issuer", k.Issuer)
+	}
+	// SHA1 is the default for most authenticators, only set it when different
+	if k.Algorithm != "" && k.Algorithm != SHA1_ALGORITHM {
+		q.Set("algorithm", string(k.Algorithm))
+	}
 
 	switch k.Type {
 	case TOTP_TOKEN:
 		out.Host = "totp"
 		q.Set("period", fmt.Sprint(k.Interval))
+	case STEAM_TOKEN:
+		out.Host = "totp"
+		q.Set("period", fmt.Sprint(k.Interval))
+		q.Set("encoder", "steam")
 	case HOTP_TOKEN:
 		out.Host = "hotp"
 		q.Set("counter", fmt.Sprint(k.Counter))
 	}
 
-	out.RawQuery = q.Encode()
+	// spaces are encoded as %20 as some authenticators do not decode +
+	out.RawQuery = strings.ReplaceAll(q.Encode(), "+", "%20")
 
 	return out.String(), nil
 }
+
+// otpauthLabel returns the "Issuer:account" label of the key, falling back
+// to its name for keys without account.
+func (k Key) otpauthLabel() string {
+	if k.Account == "" {
+		return k.Name
+	}
+	if k.Issuer == "" {
+		return k.Account
+	}
+	return k.Issuer + ":" + k.Account
+}
+
+// keyTypeFromURI returns the KeyType of an otpauth:// URI. Steam keys are
+// totp URIs with a steam encoder, some applications use a steam host instead.
+func keyTypeFromURI(u *url.URL) (KeyType, error) {
+	switch strings.ToLower(u.Host) {
+	case "totp":
+		if strings.EqualFold(u.Query().Get("encoder"), "steam") {
+			return STEAM_TOKEN, nil
+		}
+		return TOTP_TOKEN, nil
+	case "hotp":
+		return HOTP_TOKEN, nil
+	case "steam":
+		return STEAM_TOKEN, nil
+	default:
+		return 0, fmt.Errorf("unsupported otpauth type: %s", u.Host)
+	}
+}
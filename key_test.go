@@ -4,13 +4,15 @@
 package main
 
 import (
+	"encoding/base32"
 	"fmt"
 	"net/url"
 	"testing"
 	"time"
 
 	"github.com/99designs/keyring"
-	otp "github.com/hgfischer/go-otp"
+
+	"github.com/endorama/2ami/internal/otp"
 )
 
 // func TestUnmarshalJSON(t *testing.T) {
@@ -34,18 +36,9 @@ import (
 // }
 
 func _generateTotp(secret string, digits int) string {
-	now := time.Now()
-	interval := 30
-	timeInterval := uint64(time.Duration(interval) * time.Second)
-	counter := uint64(now.UnixNano()) / timeInterval
-
-	token := &otp.HOTP{
-		Secret:         string(secret),
-		Length:         uint8(digits),
-		Counter:        counter,
-		IsBase32Secret: true,
-	}
-	return token.Get()
+	decoded, _ := otp.DecodeSecret(secret)
+	token, _ := otp.TOTP(otp.Params{Secret: decoded, Digits: digits}, time.Now(), 30, 0)
+	return token
 }
 
 // openTestKeyring replaces openKeyring function to use a file backend instead
@@ -119,3 +112,228 @@ func TestKeyGenerateotpauthURI(t *testing.T) {
 		t.Errorf("wrong oauthURI. Expected %s Actual %s", want, got)
 	}
 }
+
+func TestKeyGenerateTotp_RFC6238(t *testing.T) {
+	ring, _ := openTestKeyring(t)
+
+	// Seeds and expected values from RFC 6238 Appendix B.
+	seeds := map[Algorithm]string{
+		SHA1_ALGORITHM:   "12345678901234567890",
+		SHA256_ALGORITHM: "12345678901234567890123456789012",
+		SHA512_ALGORITHM: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	testCases := []struct {
+		time      int64
+		algorithm Algorithm
+		want      string
+	}{
+		{59, SHA1_ALGORITHM, "94287082"},
+		{59, SHA256_ALGORITHM, "46119246"},
+		{59, SHA512_ALGORITHM, "90693936"},
+		{1111111109, SHA1_ALGORITHM, "07081804"},
+		{1111111109, SHA256_ALGORITHM, "68084774"},
+		{1111111109, SHA512_ALGORITHM, "25091201"},
+		{1111111111, SHA1_ALGORITHM, "14050471"},
+		{1111111111, SHA256_ALGORITHM, "67062674"},
+		{1111111111, SHA512_ALGORITHM, "99943326"},
+		{1234567890, SHA1_ALGORITHM, "89005924"},
+		{1234567890, SHA256_ALGORITHM, "91819424"},
+		{1234567890, SHA512_ALGORITHM, "93441116"},
+		{2000000000, SHA1_ALGORITHM, "69279037"},
+		{2000000000, SHA256_ALGORITHM, "90698825"},
+		{2000000000, SHA512_ALGORITHM, "38618901"},
+		{20000000000, SHA1_ALGORITHM, "65353130"},
+		{20000000000, SHA256_ALGORITHM, "77737706"},
+		{20000000000, SHA512_ALGORITHM, "47863826"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s/%d", tc.algorithm, tc.time), func(t *testing.T) {
+			key := NewKey(ring, string(tc.algorithm))
+			key.Digits = 8
+			key.Algorithm = tc.algorithm
+			_ = key.Secret(base32.StdEncoding.EncodeToString([]byte(seeds[tc.algorithm])))
+
+			got := key.totpTokenAt(time.Unix(tc.time, 0))
+			if got != tc.want {
+				t.Errorf("Wrong token. Expected %s Actual %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestKeyGenerateotpauthURI_Algorithm(t *testing.T) {
+	ring, _ := openTestKeyring(t)
+
+	key := NewKey(ring, "test")
+	key.Algorithm = SHA256_ALGORITHM
+	secretValue := "ORSXG5A="
+	_ = key.Secret(secretValue)
+	want := fmt.Sprintf("otpauth://totp/test?algorithm=SHA256&digits=6&period=30&secret=%s", url.QueryEscape(secretValue))
+	got, err := key.OtpauthURI()
+	if err != nil {
+		t.Errorf("error occurred: %s", err.Error())
+	}
+	if got != want {
+		t.Errorf("wrong oauthURI. Expected %s Actual %s", want, got)
+	}
+}
+
+// useTestKeyring makes openKeyring return a file backed keyring for the
+// duration of the test.
+func useTestKeyring(t *testing.T) keyring.Keyring {
+	t.Helper()
+
+	ring, err := openTestKeyring(t)
+	if err != nil {
+		t.Fatalf("Failed to open test keyring: %v", err)
+	}
+	original := openKeyring
+	openKeyring = func() (keyring.Keyring, error) { return ring, nil }
+	t.Cleanup(func() { openKeyring = original })
+
+	return ring
+}
+
+func TestParseKeyType(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    KeyType
+		wantErr bool
+	}{
+		{"totp", TOTP_TOKEN, false},
+		{"HOTP", HOTP_TOKEN, false},
+		{"motp", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKeyType(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseKeyType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseKeyType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVerboseString(t *testing.T) {
+	key := Key{Name: "test", Type: TOTP_TOKEN, Digits: 6, Interval: 30}
+	if got, want := key.VerboseString(), "test \t totp 6 digits every 30 seconds"; got != want {
+		t.Errorf("Wrong verbose string. Expected %q Actual %q", want, got)
+	}
+
+	key = Key{Name: "test", Type: HOTP_TOKEN, Digits: 8, Counter: 42}
+	if got, want := key.VerboseString(), "test \t hotp 8 digits at counter 42"; got != want {
+		t.Errorf("Wrong verbose string. Expected %q Actual %q", want, got)
+	}
+}
+
+func TestKeyExpiresIn(t *testing.T) {
+	testCases := []struct {
+		interval int
+		t0       int64
+		time     int64
+		want     int
+		wantStep uint64
+	}{
+		{30, 0, 59, 1, 1},
+		{30, 0, 60, 30, 2},
+		{45, 0, 100, 35, 2},
+		{90, 0, 100, 80, 1},
+		{90, 0, 1111111111, 89, 12345679},
+		{30, 10, 59, 11, 1},
+		{300, 0, 1000, 200, 3},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d/%d/%d", tc.interval, tc.t0, tc.time), func(t *testing.T) {
+			key := Key{Interval: tc.interval, T0: tc.t0}
+			now := time.Unix(tc.time, 0)
+			if got := key.expiresInAt(now); got != tc.want {
+				t.Errorf("Wrong expiration. Expected %d Actual %d", tc.want, got)
+			}
+			if got := key.timeStepAt(now); got != tc.wantStep {
+				t.Errorf("Wrong time step. Expected %d Actual %d", tc.wantStep, got)
+			}
+		})
+	}
+}
+
+func TestKeyGenerateSteam(t *testing.T) {
+	ring, _ := openTestKeyring(t)
+
+	key := NewKey(ring, "steam")
+	key.Type = STEAM_TOKEN
+	key.Digits = otp.SteamDigits
+	_ = key.Secret(base32.StdEncoding.EncodeToString([]byte("12345678901234567890")))
+
+	// Time step 0 truncates to 1284755224, see RFC 4226 Appendix D.
+	if got, want := key.totpTokenAt(time.Unix(0, 0)), "GG5F5"; got != want {
+		t.Errorf("Wrong token. Expected %s Actual %s", want, got)
+	}
+}
+
+func TestKeyTypeFromURI(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    KeyType
+		wantErr bool
+	}{
+		{"otpauth://totp/test?secret=ORSXG5A", TOTP_TOKEN, false},
+		{"otpauth://hotp/test?secret=ORSXG5A&counter=1", HOTP_TOKEN, false},
+		{"otpauth://totp/test?secret=ORSXG5A&encoder=steam", STEAM_TOKEN, false},
+		{"otpauth://steam/test?secret=ORSXG5A", STEAM_TOKEN, false},
+		{"otpauth://motp/test?secret=ORSXG5A", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			u, err := url.Parse(tt.uri)
+			if err != nil {
+				t.Fatalf("cannot parse uri: %v", err)
+			}
+			got, err := keyTypeFromURI(u)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("keyTypeFromURI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("keyTypeFromURI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyGenerateotpauthURI_Steam(t *testing.T) {
+	ring, _ := openTestKeyring(t)
+
+	key := NewKey(ring, "test")
+	key.Type = STEAM_TOKEN
+	key.Digits = otp.SteamDigits
+	secretValue := "ORSXG5A="
+	_ = key.Secret(secretValue)
+	want := fmt.Sprintf("otpauth://totp/test?digits=5&encoder=steam&period=30&secret=%s", url.QueryEscape(secretValue))
+	got, err := key.OtpauthURI()
+	if err != nil {
+		t.Errorf("error occurred: %s", err.Error())
+	}
+	if got != want {
+		t.Errorf("wrong oauthURI. Expected %s Actual %s", want, got)
+	}
+}
+
+func TestKeyGenerateotpauthURI_IssuerAndAccount(t *testing.T) {
+	ring, _ := openTestKeyring(t)
+
+	key := NewKey(ring, "ACME Co - john/doe")
+	key.Issuer = "ACME Co"
+	key.Account = "john/doe@example.com"
+	_ = key.Secret("JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/ACME%20Co:john%2Fdoe@example.com?digits=6&issuer=ACME%20Co&period=30&secret=JBSWY3DPEHPK3PXP"
+	got, err := key.OtpauthURI()
+	if err != nil {
+		t.Errorf("error occurred: %s", err.Error())
+	}
+	if got != want {
+		t.Errorf("wrong oauthURI. Expected %s Actual %s", want, got)
+	}
+}
@@ -2,26 +2,152 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/99designs/keyring"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
-func openKeyring() (keyring.Keyring, error) {
+// openKeyring opens the keyring holding 2FA secrets. It is a variable so tests
+// can replace it with a file backed keyring.
+var openKeyring = openSystemKeyring
+
+// defaultBackends are tried in order when no backend is configured.
+var defaultBackends = []keyring.BackendType{
+	keyring.SecretServiceBackend,
+	keyring.KeychainBackend,
+	keyring.WinCredBackend,
+}
+
+// supportedBackends are the backends that can be set with 2AMI_BACKEND.
+var supportedBackends = []keyring.BackendType{
+	keyring.SecretServiceBackend,
+	keyring.KWalletBackend,
+	keyring.PassBackend,
+	keyring.FileBackend,
+	keyring.KeyCtlBackend,
+	keyring.KeychainBackend,
+	keyring.WinCredBackend,
+}
+
+func openSystemKeyring() (keyring.Keyring, error) {
+	return openKeyringWith(viper.GetString("backend"), viper.GetString("ring"))
+}
+
+// openKeyringWith opens the named ring of backend, or of the first default
+// backend available when backend is empty.
+func openKeyringWith(backend string, ring string) (keyring.Keyring, error) {
+	config, err := keyringConfig(backend, ring)
+	if err != nil {
+		return nil, err
+	}
+	opened, err := keyring.Open(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open keyring: %w", err)
+	}
+	return opened, nil
+}
+
+func keyringConfig(backend string, ring string) (keyring.Config, error) {
+	backends := defaultBackends
+	if backend != "" {
+		backendType, err := parseBackend(backend)
+		if err != nil {
+			return keyring.Config{}, err
+		}
+		backends = []keyring.BackendType{backendType}
+	}
+
 	config := keyring.Config{
-		AllowedBackends: []keyring.BackendType{
-			keyring.SecretServiceBackend,
-			keyring.KeychainBackend,
-			keyring.WinCredBackend,
-		},
+		AllowedBackends:         backends,
 		ServiceName:             "2ami",
-		KeychainName:            viper.GetString("ring"),
-		LibSecretCollectionName: viper.GetString("ring"),
-		WinCredPrefix:           viper.GetString("ring"),
+		KeychainName:            ring,
+		LibSecretCollectionName: ring,
+		WinCredPrefix:           ring,
+		KWalletAppID:            "2ami",
+		KWalletFolder:           ring,
+		PassPrefix:              filepath.Join("2ami", ring),
+		KeyCtlScope:             "user",
+		// each ring is a directory of the file backend
+		FileDir:          filepath.Join(viper.GetString("file_dir"), ring),
+		FilePasswordFunc: promptFilePassphrase,
+	}
+	if len(backends) == 1 && backends[0] == keyring.KeyCtlBackend {
+		// keyctl names its keyring after the service only
+		config.ServiceName = fmt.Sprintf("2ami-%s", ring)
+	}
+	return config, nil
+}
+
+// resolveBackend returns the backend opened for name, the first default
+// backend available when name is empty.
+func resolveBackend(name string) (keyring.BackendType, error) {
+	if name != "" {
+		return parseBackend(name)
+	}
+	available := keyring.AvailableBackends()
+	for _, backend := range defaultBackends {
+		for _, a := range available {
+			if backend == a {
+				return backend, nil
+			}
+		}
+	}
+	return keyring.InvalidBackend, keyring.ErrNoAvailImpl
+}
+
+func parseBackend(name string) (keyring.BackendType, error) {
+	for _, backend := range supportedBackends {
+		if strings.EqualFold(name, string(backend)) {
+			return backend, nil
+		}
+	}
+
+	names := make([]string, 0, len(supportedBackends))
+	for _, backend := range supportedBackends {
+		names = append(names, string(backend))
+	}
+	return keyring.InvalidBackend, fmt.Errorf("unsupported keyring backend: %s (supported: %s)", name, strings.Join(names, ", "))
+}
+
+// probeItem returns an item with a unique name, written to find out how a
+// keyring behaves and removed right after.
+func probeItem() keyring.Item {
+	return keyring.Item{
+		Key:         fmt.Sprintf("2ami-probe-%d", time.Now().UnixNano()),
+		Data:        []byte("probe"),
+		Description: "2ami probe",
+	}
+}
+
+// promptFilePassphrase returns the passphrase of the file backend from
+// 2AMI_FILE_PASSPHRASE, or asks it on the terminal. The prompt goes to stderr,
+// to keep stdout for tokens and exported keys.
+func promptFilePassphrase(prompt string) (string, error) {
+	if passphrase := viper.GetString("file_passphrase"); passphrase != "" {
+		return passphrase, nil
 	}
-	ring, err := keyring.Open(config)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// stdin may be a pipe, as with add --uri, ask on the terminal instead
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return "", fmt.Errorf("cannot ask passphrase without a terminal, set 2AMI_FILE_PASSPHRASE: %w", err)
+		}
+		defer tty.Close()
+		fd = int(tty.Fd())
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
 	if err != nil {
-		return nil, fmt.Errorf("cannot open keyring: %w", err)
+		return "", fmt.Errorf("cannot read passphrase: %w", err)
 	}
-	return ring, nil
+	return string(passphrase), nil
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyringConfig_Backends(t *testing.T) {
+	tests := []struct {
+		backend string
+		want    []keyring.BackendType
+	}{
+		{"", defaultBackends},
+		{"secret-service", []keyring.BackendType{keyring.SecretServiceBackend}},
+		{"kwallet", []keyring.BackendType{keyring.KWalletBackend}},
+		{"pass", []keyring.BackendType{keyring.PassBackend}},
+		{"file", []keyring.BackendType{keyring.FileBackend}},
+		{"keyctl", []keyring.BackendType{keyring.KeyCtlBackend}},
+		{"KeyCtl", []keyring.BackendType{keyring.KeyCtlBackend}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.backend, func(t *testing.T) {
+			config, err := keyringConfig(tt.backend, "login")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, config.AllowedBackends)
+		})
+	}
+}
+
+func TestKeyringConfig_Ring(t *testing.T) {
+	dir := t.TempDir()
+	original := viper.GetString("file_dir")
+	viper.Set("file_dir", dir)
+	t.Cleanup(func() { viper.Set("file_dir", original) })
+
+	config, err := keyringConfig("file", "work")
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(dir, "work"), config.FileDir)
+	assert.NotNil(t, config.FilePasswordFunc)
+	assert.Equal(t, "work", config.LibSecretCollectionName)
+	assert.Equal(t, "work", config.KWalletFolder)
+	assert.Equal(t, "2ami/work", config.PassPrefix)
+}
+
+func TestKeyringConfig_UnsupportedBackend(t *testing.T) {
+	_, err := keyringConfig("vault", "login")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported keyring backend: vault")
+}
+
+func TestKeyringConfig_KeyCtlRing(t *testing.T) {
+	login, err := keyringConfig("keyctl", "login")
+	require.NoError(t, err)
+	work, err := keyringConfig("keyctl", "work")
+	require.NoError(t, err)
+
+	assert.Equal(t, "2ami-login", login.ServiceName)
+	assert.Equal(t, "2ami-work", work.ServiceName)
+
+	// other backends keep their items under the 2ami service
+	config, err := keyringConfig("secret-service", "work")
+	require.NoError(t, err)
+	assert.Equal(t, "2ami", config.ServiceName)
+}
+
+func TestPromptFilePassphrase_Env(t *testing.T) {
+	original := viper.GetString("file_passphrase")
+	viper.Set("file_passphrase", "correct horse")
+	t.Cleanup(func() { viper.Set("file_passphrase", original) })
+
+	passphrase, err := promptFilePassphrase("Passphrase")
+	require.NoError(t, err)
+	assert.Equal(t, "correct horse", passphrase)
+}
+
+func TestResolveBackend(t *testing.T) {
+	backend, err := resolveBackend("Secret-Service")
+	require.NoError(t, err)
+	assert.Equal(t, keyring.SecretServiceBackend, backend)
+
+	// the default is the backend that would be opened
+	backend, err = resolveBackend("")
+	if err != nil {
+		assert.ErrorIs(t, err, keyring.ErrNoAvailImpl)
+		return
+	}
+	assert.Contains(t, defaultBackends, backend)
+	assert.Contains(t, keyring.AvailableBackends(), backend)
+}
@@ -4,21 +4,25 @@
 package main
 
 import (
-	"encoding/base32"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/99designs/keyring"
 	"github.com/OpenPeeDeeP/xdg"
 	docopt "github.com/docopt/docopt.go"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 
 	"github.com/atotto/clipboard"
 	"github.com/mitchellh/cli"
+
+	"github.com/endorama/2ami/internal/otp"
 )
 
 var (
@@ -32,34 +36,75 @@ func usage() string {
 	return `Two factor authenticator for your command line.
 
 Usage:
-  2ami add <name> [--digits=<digits>] [--interval=<seconds>] [--verbose]
+  2ami add --uri [<name>] [--verbose]
+  2ami add --qr=<image> [--verbose]
+  2ami add <name> [--type=<type>] [--digits=<digits>] [--interval=<seconds>] [--counter=<counter>] [--algorithm=<algorithm>] [--t0=<seconds>] [--verbose]
   2ami dump [<name>] [--verbose]
   2ami generate <name> [-c|--clip] [--verbose]
   2ami list [--verbose]
   2ami remove <name> [--verbose]
-  2ami rename <old-name> <new-name>
-  2ami backup <file-path>
-  2ami restore <file-path> [--format=<format>]
+  2ami rename <old-name> <new-name> [--force]
+  2ami edit <name> [--type=<type>] [--digits=<digits>] [--interval=<seconds>] [--counter=<counter>] [--algorithm=<algorithm>] [--issuer=<issuer>] [--account=<account>] [--secret] [--verbose]
+  2ami import-uris <file-path> [--verbose]
+  2ami export-uri <name>
+  2ami export-uris (--all | <names>...)
+  2ami qr <name> [--png=<file>]
+  2ami export --format=<format> (--all | <names>...)
+  2ami backup verify <file-path>
+  2ami backup [--format=<format>] <file-path>
+  2ami restore <file-path> [--format=<format>] [--only=<names>] [--match=<glob>] [--on-conflict=<policy>] [--dry-run] [--strict]
+  2ami restore <file-path> --list [--format=<format>] [--only=<names>] [--match=<glob>]
+  2ami ring migrate --to-ring=<ring> [--to-backend=<backend>]
+  2ami doctor [--fix]
   2ami -h | --help
   2ami --version
 
 Commands:
-  add       Add a new key.
-  dump      Dump keys informations (without secrets).
-  generate  Generate a token from a known key.
-  list      List known keys.
-  remove    Remove specified key.
-  backup    Backup keys to a specified file (with encryption)
-  restore   Restore keys from a specified encrypted file
+  add          Add a new key.
+  dump         Dump keys informations (without secrets).
+  generate     Generate a token from a known key.
+  list         List known keys.
+  remove       Remove specified key.
+  edit         Change the settings or the secret of a key.
+  import-uris  Add keys from a file of otpauth:// URIs, one per line.
+  export-uri   Print the otpauth:// URI of a key (with its secret).
+  export-uris  Print the otpauth:// URIs of many keys (with their secrets).
+  qr           Show a key as a QR code, to scan it with another authenticator.
+  export       Print keys in another authenticator format (with their secrets).
+  backup       Backup keys to a specified file (with encryption), or verify a backup.
+  restore      Restore keys from a specified encrypted file
+  ring         Move secrets to another keyring or backend.
+  doctor       Check that keys and keyring secrets are consistent.
 
 Options:
-  -h --help             Show this screen.
-  --version             Show version.
-  --verbose             Enable verbose output.
-  --digits=<digits>     Number of token digits.
-  --interval=<seconds>  Interval in seconds between token generation.
-  --format=<format>     Backup format to restore from (2ami, aegis, etc.).
-  -c --clip             Copy result to the clipboard.
+  -h --help                Show this screen.
+  --version                Show version.
+  --uri                    Read an otpauth:// URI from a hidden prompt or stdin.
+  --qr=<image>             Read keys from the QR code in a PNG or JPEG image.
+  --all                    Apply to all keys.
+  --png=<file>             Write the QR code as a PNG image instead of printing it.
+  --verbose                Enable verbose output.
+  --type=<type>            Key type, totp, hotp or steam, default to totp when adding.
+  --digits=<digits>        Number of token digits.
+  --interval=<seconds>     Interval in seconds between token generation.
+  --counter=<counter>      Initial counter of a hotp key.
+  --algorithm=<algorithm>  Hash algorithm for token generation (SHA1, SHA256, SHA512).
+  --t0=<seconds>           Unix time from which totp time steps are counted.
+  --issuer=<issuer>        Provider or service of the key.
+  --account=<account>      Account name of the key.
+  --secret                 Ask a new secret for the key, keeping its settings.
+  --format=<format>        Format to backup to, restore from or export to (2ami, aegis, google-migration).
+  --on-conflict=<policy>   What to do with keys that already exist (skip, overwrite, rename, ask) [default: ask].
+  --dry-run                Only print what would be added, overwritten, renamed or skipped.
+  --strict                 Exit with an error if any key is skipped.
+  --only=<names>           Restore only the keys with these comma separated names.
+  --match=<glob>           Restore only the keys whose name matches a glob pattern.
+  --list                   Print the names of the keys in a backup, without restoring them.
+  -c --clip                Copy result to the clipboard.
+  --to-ring=<ring>         Keyring to move secrets to.
+  --to-backend=<backend>   Keyring backend to move secrets to, default to the current one.
+  --force                  Overwrite the key named new-name if it exists.
+  --fix                    Remove keys without a secret, re-link orphaned secrets and fix settings.
 
 Environment variables:
   2AMI_DB    Path to the database where 2FA keys information are stored.
@@ -67,6 +112,12 @@ Environment variables:
              For non Linux values of XDG_DATA_HOME see https://github.com/OpenPeeDeeP/xdg
   2AMI_RING	 Name of the keyring/keychain where 2FA secrets will be stored.
              Default to "login".
+  2AMI_BACKEND  Keyring backend where 2FA secrets will be stored: secret-service,
+             kwallet, pass, file, keyctl, keychain or wincred.
+             Default to the first available of secret-service, keychain and wincred.
+  2AMI_FILE_DIR  Directory of the file backend, encrypted with a prompted passphrase.
+             Default to $XDG_DATA_HOME/2ami/keyring.
+  2AMI_FILE_PASSPHRASE  Passphrase of the file backend, to use it without a terminal.
 `
 }
 
@@ -90,6 +141,9 @@ func main() {
 
 	viper.SetDefault("db", filepath.Join(xdg.DataHome(), "2ami", "database.boltdb"))
 	viper.SetDefault("ring", "login")
+	viper.SetDefault("backend", "")
+	viper.SetDefault("file_dir", filepath.Join(xdg.DataHome(), "2ami", "keyring"))
+	viper.SetDefault("file_passphrase", "")
 
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("2AMI")
@@ -125,6 +179,28 @@ func main() {
 
 	// deleteAllKeys(storage) //nolint:unused
 
+	if arguments["add"].(bool) && arguments["--uri"].(bool) {
+		name := ""
+		if arguments["<name>"] != nil {
+			name = arguments["<name>"].(string)
+		}
+		err := addFromURIWithPrompt(&ui, storage, name)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if arguments["add"].(bool) && arguments["--qr"] != nil {
+		errors := addFromQRCode(&ui, storage, arguments["--qr"].(string))
+		for _, err := range errors {
+			ui.Error(err.Error())
+		}
+		if len(errors) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	if arguments["add"].(bool) {
 		name := arguments["<name>"].(string)
 		if name == "" {
@@ -132,7 +208,15 @@ func main() {
 			os.Exit(1)
 		}
 
-		err := addWithPrompt(&ui, storage, name, arguments["--digits"], arguments["--interval"])
+		options := keyOptions{
+			Type:      arguments["--type"],
+			Digits:    arguments["--digits"],
+			Interval:  arguments["--interval"],
+			Counter:   arguments["--counter"],
+			Algorithm: arguments["--algorithm"],
+			T0:        arguments["--t0"],
+		}
+		err := addWithPrompt(&ui, storage, name, options)
 		if err != nil {
 			ui.Error("An unexpected error occurred. Use DEBUG=true to show logs.")
 			debugPrint(fmt.Sprintf("%s", err))
@@ -140,6 +224,29 @@ func main() {
 		}
 		os.Exit(0)
 	}
+	if arguments["edit"].(bool) {
+		name := arguments["<name>"].(string)
+		options := keyOptions{
+			Type:      arguments["--type"],
+			Digits:    arguments["--digits"],
+			Interval:  arguments["--interval"],
+			Counter:   arguments["--counter"],
+			Algorithm: arguments["--algorithm"],
+			Issuer:    arguments["--issuer"],
+			Account:   arguments["--account"],
+		}
+		askSecret := arguments["--secret"].(bool)
+		if options == (keyOptions{}) && !askSecret {
+			ui.Error("nothing to edit, set at least one option")
+			os.Exit(1)
+		}
+		err := editWithPrompt(&ui, storage, name, options, askSecret)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	if arguments["dump"].(bool) {
 		if arguments["<name>"] == nil {
 			errors := dumpAllKeys(storage)
@@ -154,6 +261,30 @@ func main() {
 		}
 		os.Exit(0)
 	}
+	if arguments["backup"].(bool) && arguments["verify"].(bool) {
+		data, err := os.ReadFile(arguments["<file-path>"].(string))
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error reading backup file: %s", err))
+			os.Exit(1)
+		}
+
+		password, err := ui.AskSecret("Password for backup file: ")
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error reading stdin: %s", err))
+			os.Exit(1)
+		}
+
+		report, err := verifyBackup(storage, string(data), password)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error verifying backup: %s", err))
+			os.Exit(1)
+		}
+		printBackupReport(&ui, report)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	if arguments["backup"].(bool) {
 		backupPath := arguments["<file-path>"].(string)
 		if backupPath == "" {
@@ -161,13 +292,28 @@ func main() {
 			os.Exit(1)
 		}
 
+		format := backupFormat2ami // default format
+		if arguments["--format"] != nil {
+			format = arguments["--format"].(string)
+		}
+		if format != backupFormat2ami && format != backupFormatAegis {
+			ui.Error(fmt.Sprintf("unsupported backup format: %s", format))
+			os.Exit(1)
+		}
+
 		password, err := ui.AskSecret("Password for backup file: ")
 		if err != nil {
 			ui.Error(fmt.Sprintf("Error reading stdin: %s", err))
 			os.Exit(1)
 		}
 
-		data, err := backupAllKeys(storage, password)
+		var data string
+		switch format {
+		case backupFormat2ami:
+			data, err = backupAllKeys(storage, password)
+		case backupFormatAegis:
+			data, err = backupAllKeysAegis(storage, password)
+		}
 		if err != nil {
 			ui.Error(fmt.Sprintf("Error during backup: %s", err))
 			os.Exit(1)
@@ -191,23 +337,69 @@ func main() {
 			format = arguments["--format"].(string)
 		}
 
-		data, err := os.ReadFile(backupPath)
+		policy, err := parseConflictPolicy(arguments["--on-conflict"].(string))
 		if err != nil {
-			ui.Error(fmt.Sprintf("Error reading backup file: %s", err))
+			ui.Error(err.Error())
 			os.Exit(1)
 		}
+		options := restoreOptions{
+			DryRun:     arguments["--dry-run"].(bool),
+			OnConflict: policy,
+		}
+		if arguments["--only"] != nil {
+			for _, name := range strings.Split(arguments["--only"].(string), ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					options.Only = append(options.Only, name)
+				}
+			}
+		}
+		if arguments["--match"] != nil {
+			options.Match = arguments["--match"].(string)
+		}
 
-		password, err := ui.AskSecret("Password for backup file: ")
+		data, err := os.ReadFile(backupPath)
 		if err != nil {
-			ui.Error(fmt.Sprintf("Error reading stdin: %s", err))
+			ui.Error(fmt.Sprintf("Error reading backup file: %s", err))
 			os.Exit(1)
 		}
 
-		err = restore(storage, string(data), password, format)
+		// migration payloads are not encrypted
+		password := ""
+		if format != backupFormatGoogleMigration {
+			password, err = ui.AskSecret("Password for backup file: ")
+			if err != nil {
+				ui.Error(fmt.Sprintf("Error reading stdin: %s", err))
+				os.Exit(1)
+			}
+		}
+
+		if arguments["--list"].(bool) {
+			names, err := listBackup(string(data), password, format, options)
+			if err != nil {
+				ui.Error(fmt.Sprintf("Error reading backup: %s", err))
+				os.Exit(1)
+			}
+			for _, name := range names {
+				ui.Output(name)
+			}
+			os.Exit(0)
+		}
+
+		result, err := restore(&ui, storage, string(data), password, format, options)
 		if err != nil {
+			if len(result.Failed) > 0 {
+				printRestoreResult(&ui, result)
+			}
 			ui.Error(fmt.Sprintf("Error during restore: %s", err))
 			os.Exit(1)
 		}
+		if options.DryRun {
+			os.Exit(0)
+		}
+		printRestoreResult(&ui, result)
+		if arguments["--strict"].(bool) && len(result.Skipped) > 0 {
+			os.Exit(1)
+		}
 	}
 	if arguments["generate"].(bool) {
 		name := arguments["<name>"].(string)
@@ -218,14 +410,17 @@ func main() {
 		token, err := generate(storage, name)
 		if err != nil {
 			ui.Error(err.Error())
+			os.Exit(1)
 		}
 
 		if arguments["--clip"].(bool) {
 			err = clipboard.WriteAll(token.Value)
 			ui.Error(fmt.Sprintf("Cannot copy to clipboard: %s", err))
 		} else {
-			if verbose {
-				ui.Info(fmt.Sprintf("%s ( %d seconds left )\n", token.Value, token.ExpiresIn))
+			if verbose && token.Type == HOTP_TOKEN {
+				ui.Info(fmt.Sprintf("%s ( counter %d )\n", token.Value, token.Counter))
+			} else if verbose {
+				ui.Info(fmt.Sprintf("%s ( %d seconds left, time step %d )\n", token.Value, token.ExpiresIn, token.TimeStep))
 			} else {
 				ui.Info(token.Value)
 			}
@@ -253,7 +448,7 @@ func main() {
 			ui.Error("old-name and new-name are equal, aborting")
 			os.Exit(1)
 		}
-		err := rename(&ui, storage, oldName, newName)
+		err := rename(&ui, storage, oldName, newName, arguments["--force"].(bool))
 		if err != nil {
 			ui.Error(err.Error())
 			os.Exit(1)
@@ -261,6 +456,167 @@ func main() {
 		ui.Info("Key renamed")
 		os.Exit(0)
 	}
+	if arguments["import-uris"].(bool) {
+		errors := importURIs(&ui, storage, arguments["<file-path>"].(string))
+		for _, err := range errors {
+			ui.Error(err.Error())
+		}
+		if len(errors) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if arguments["export-uri"].(bool) || arguments["export-uris"].(bool) {
+		var names []string
+		if arguments["export-uri"].(bool) {
+			names = []string{arguments["<name>"].(string)}
+		} else {
+			names = arguments["<names>"].([]string)
+		}
+		if arguments["--all"].(bool) {
+			names, err = storage.ListKey()
+			if err != nil {
+				ui.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// the confirmation goes to stderr, to keep stdout for the URIs
+		err := confirmSecretExposure(&cli.BasicUi{Reader: os.Stdin, Writer: os.Stderr}, "secrets")
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+
+		uris, err := exportURIs(storage, names)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		for _, uri := range uris {
+			ui.Output(uri)
+		}
+		os.Exit(0)
+	}
+	if arguments["export"].(bool) {
+		format := arguments["--format"].(string)
+		if format != backupFormatGoogleMigration {
+			ui.Error(fmt.Sprintf("unsupported export format: %s", format))
+			os.Exit(1)
+		}
+
+		names := arguments["<names>"].([]string)
+		if arguments["--all"].(bool) {
+			names, err = storage.ListKey()
+			if err != nil {
+				ui.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// the confirmation goes to stderr, to keep stdout for the URIs
+		err := confirmSecretExposure(&cli.BasicUi{Reader: os.Stdin, Writer: os.Stderr}, "secrets")
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+
+		uris, err := exportGoogleMigration(storage, names)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		for _, uri := range uris {
+			ui.Output(uri)
+		}
+		os.Exit(0)
+	}
+	if arguments["qr"].(bool) {
+		name := arguments["<name>"].(string)
+
+		err := confirmSecretExposure(&cli.BasicUi{Reader: os.Stdin, Writer: os.Stderr}, "the secret of "+name)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+
+		qr, err := keyQRCode(storage, name)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		if arguments["--png"] != nil {
+			pngPath := arguments["--png"].(string)
+			if err := writeQRCodePNG(qr, pngPath); err != nil {
+				ui.Error(fmt.Sprintf("Error writing QR code: %s", err))
+				os.Exit(1)
+			}
+			ui.Info(fmt.Sprintf("QR code written to %s", pngPath))
+			os.Exit(0)
+		}
+		ui.Output(renderQRCode(qr))
+		os.Exit(0)
+	}
+	if arguments["doctor"].(bool) {
+		fix := arguments["--fix"].(bool)
+		problems, err := doctor(&ui, storage, fix)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		printDoctorReport(&ui, problems, fix)
+		for _, p := range problems {
+			if !p.Fixed {
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+
+	if arguments["ring"].(bool) && arguments["migrate"].(bool) {
+		fromBackend, err := resolveBackend(viper.GetString("backend"))
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		fromRing := viper.GetString("ring")
+		toBackend := fromBackend
+		if arguments["--to-backend"] != nil {
+			toBackend, err = resolveBackend(arguments["--to-backend"].(string))
+			if err != nil {
+				ui.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+		toRing := arguments["--to-ring"].(string)
+		if toRing == fromRing && toBackend == fromBackend {
+			ui.Error("target keyring is the current one, aborting")
+			os.Exit(1)
+		}
+
+		source, err := openKeyring()
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		target, err := openKeyringWith(string(toBackend), toRing)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+
+		err = migrateRing(&ui, storage, source, target)
+		if err != nil {
+			ui.Error(err.Error())
+			os.Exit(1)
+		}
+		if toBackend != fromBackend {
+			ui.Info(fmt.Sprintf("Set 2AMI_RING=%s and 2AMI_BACKEND=%s to use the new keyring", toRing, toBackend))
+		} else {
+			ui.Info(fmt.Sprintf("Set 2AMI_RING=%s to use the new keyring", toRing))
+		}
+		os.Exit(0)
+	}
 	if arguments["--version"].(bool) {
 		ui.Output(version)
 		os.Exit(0)
@@ -276,13 +632,160 @@ func checkAndEnableDebugMode() {
 	}
 }
 
-func addWithPrompt(ui cli.Ui, storage Storage, name string, digits interface{}, interval interface{}) error {
+// keyOptions holds the optional key parameters. Values come either from
+// docopt (strings) or from backup formats (ints, string lists and bools); nil
+// keeps the Key default.
+type keyOptions struct {
+	Type      interface{}
+	Digits    interface{}
+	Interval  interface{}
+	Counter   interface{}
+	Algorithm interface{}
+	T0        interface{}
+	Issuer    interface{}
+	Account   interface{}
+	Tags      interface{}
+	Note      interface{}
+	Favorite  interface{}
+}
+
+// apply sets the non nil options on key.
+func (o keyOptions) apply(key *Key) error {
+	var err error
+	if o.Type != nil {
+		switch v := o.Type.(type) {
+		case string:
+			key.Type, err = parseKeyType(v)
+			if err != nil {
+				return err
+			}
+		case KeyType:
+			key.Type = v
+		default:
+			return fmt.Errorf("unsupported type for type: %T", o.Type)
+		}
+		if key.Type == STEAM_TOKEN {
+			// Steam Guard tokens are fixed, options below can still override
+			key.Digits = otp.SteamDigits
+			key.Interval = 30
+			key.Algorithm = SHA1_ALGORITHM
+		}
+	}
+	if o.Digits != nil {
+		key.Digits, err = optionToInt("digits", o.Digits)
+		if err != nil {
+			return err
+		}
+	}
+	// Steam Guard tokens are not decimal, their length is not bound by otp
+	if (o.Digits != nil || o.Type != nil) && key.Type != STEAM_TOKEN {
+		if key.Digits < otp.MinDigits || key.Digits > otp.MaxDigits {
+			return fmt.Errorf("digits must be between %d and %d: %d", otp.MinDigits, otp.MaxDigits, key.Digits)
+		}
+	}
+	if o.Interval != nil {
+		key.Interval, err = optionToInt("interval", o.Interval)
+		if err != nil {
+			return err
+		}
+		if key.Interval <= 0 {
+			return fmt.Errorf("interval must be greater than zero: %d", key.Interval)
+		}
+	}
+	if o.Counter != nil {
+		key.Counter, err = optionToInt("counter", o.Counter)
+		if err != nil {
+			return err
+		}
+	}
+	if o.Algorithm != nil {
+		switch v := o.Algorithm.(type) {
+		case string:
+			key.Algorithm, err = otp.ParseAlgorithm(v)
+			if err != nil {
+				return err
+			}
+		case Algorithm:
+			key.Algorithm = v
+		default:
+			return fmt.Errorf("unsupported type for algorithm: %T", o.Algorithm)
+		}
+	}
+	if o.T0 != nil {
+		t0, err := optionToInt("t0", o.T0)
+		if err != nil {
+			return err
+		}
+		if t0 < 0 {
+			return fmt.Errorf("t0 cannot be negative: %d", t0)
+		}
+		key.T0 = int64(t0)
+	}
+	if o.Issuer != nil {
+		key.Issuer, err = optionToString("issuer", o.Issuer)
+		if err != nil {
+			return err
+		}
+	}
+	if o.Account != nil {
+		key.Account, err = optionToString("account", o.Account)
+		if err != nil {
+			return err
+		}
+	}
+	if o.Tags != nil {
+		tags, ok := o.Tags.([]string)
+		if !ok {
+			return fmt.Errorf("unsupported type for tags: %T", o.Tags)
+		}
+		key.Tags = tags
+	}
+	if o.Note != nil {
+		key.Note, err = optionToString("note", o.Note)
+		if err != nil {
+			return err
+		}
+	}
+	if o.Favorite != nil {
+		favorite, ok := o.Favorite.(bool)
+		if !ok {
+			return fmt.Errorf("unsupported type for favorite: %T", o.Favorite)
+		}
+		key.Favorite = favorite
+	}
+	return nil
+}
+
+func optionToInt(name string, value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case string:
+		i, err := convertStringToInt(v)
+		if err != nil {
+			return 0, fmt.Errorf("cannot convert string to int: %w", err)
+		}
+		return i, nil
+	default:
+		return 0, fmt.Errorf("unsupported type for %s: %T", name, value)
+	}
+}
+
+func optionToString(name string, value interface{}) (string, error) {
+	v, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("unsupported type for %s: %T", name, value)
+	}
+	return v, nil
+}
+
+func addWithPrompt(ui cli.Ui, storage Storage, name string, options keyOptions) error {
 	secret, err := ui.AskSecret(fmt.Sprintf("2fa secret for %s ( will not be printed ): ", name))
 	if err != nil {
 		return err
 	}
 	secret = sanitizeSecret(secret)
-	if err := add(storage, name, secret, digits, interval); err != nil {
+	if err := add(storage, name, secret, options); err != nil {
 		return err
 	}
 
@@ -290,42 +793,189 @@ func addWithPrompt(ui cli.Ui, storage Storage, name string, digits interface{},
 	return nil
 }
 
-func add(storage Storage, name string, secret string, digits interface{}, interval interface{}) error {
+// addFromURIWithPrompt adds the key described by an otpauth:// URI. The URI
+// contains the secret, so it is read from a hidden prompt, or from stdin when
+// it is not a terminal. A non empty name overrides the one from the URI.
+func addFromURIWithPrompt(ui cli.Ui, storage Storage, name string) error {
+	var input string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		var err error
+		input, err = ui.AskSecret("otpauth URI ( will not be printed ): ")
+		if err != nil {
+			return err
+		}
+	} else {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("cannot read stdin: %w", err)
+		}
+		input = string(data)
+	}
+
+	key, err := parseOtpauthURI(input)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		key.Name = name
+	}
+	if err := add(storage, key.Name, key.Secret, key.Options); err != nil {
+		return err
+	}
+
+	ui.Info(fmt.Sprintf("Key %s successfully added", key.Name))
+	return nil
+}
+
+// addFromQRCode adds the keys in the QR code image at path, either a single
+// otpauth:// URI or a Google Authenticator migration payload.
+func addFromQRCode(ui cli.Ui, storage Storage, path string) (errors []error) {
+	text, err := decodeQRCodeImage(path)
+	if err != nil {
+		return []error{err}
+	}
+
+	keys, err := keysFromURI(text)
+	if err != nil {
+		return []error{fmt.Errorf("QR code does not contain a valid key: %w", err)}
+	}
+
+	for _, key := range keys {
+		if err := add(storage, key.Name, key.Secret, key.Options); err != nil {
+			errors = append(errors, fmt.Errorf("cannot add %s: %w", key.Name, err))
+			continue
+		}
+		ui.Info(fmt.Sprintf("Key %s successfully added", key.Name))
+	}
+
+	return errors
+}
+
+// importURIs adds a key for each otpauth:// URI in the file at path. Invalid
+// lines are reported and do not prevent the others from being imported.
+func importURIs(ui cli.Ui, storage Storage, path string) (errors []error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return []error{fmt.Errorf("cannot open file: %w", err)}
+	}
+	defer file.Close()
+
+	lines, err := readOtpauthURIs(file)
+	if err != nil {
+		return []error{fmt.Errorf("cannot read file: %w", err)}
+	}
+
+	for _, line := range lines {
+		key, err := parseOtpauthURI(line.URI)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("line %d: %w", line.Number, err))
+			continue
+		}
+		if err := add(storage, key.Name, key.Secret, key.Options); err != nil {
+			errors = append(errors, fmt.Errorf("line %d: cannot add %s: %w", line.Number, key.Name, err))
+			continue
+		}
+		ui.Info(fmt.Sprintf("Key %s successfully added", key.Name))
+	}
+
+	return errors
+}
+
+// buildKey returns a key named name with options applied, checking secret
+// without storing anything.
+func buildKey(ring keyring.Keyring, name string, secret string, options keyOptions) (Key, error) {
 	if err := isValidBase32(secret); err != nil {
-		return fmt.Errorf("secret is not valid: %w", err)
+		return Key{}, fmt.Errorf("secret is not valid: %w", err)
+	}
+
+	key := NewKey(ring, name)
+	if err := options.apply(&key); err != nil {
+		return Key{}, err
 	}
+	return key, nil
+}
 
+func editWithPrompt(ui cli.Ui, storage Storage, name string, options keyOptions, askSecret bool) error {
+	secret := ""
+	if askSecret {
+		var err error
+		secret, err = ui.AskSecret(fmt.Sprintf("new 2fa secret for %s ( will not be printed ): ", name))
+		if err != nil {
+			return err
+		}
+		secret = sanitizeSecret(secret)
+	}
+	if err := edit(storage, name, secret, options); err != nil {
+		return err
+	}
+
+	ui.Info("Key successfully updated")
+	return nil
+}
+
+// edit applies options to the stored key name and, when secret is not empty,
+// replaces its secret. Other settings and the secret are left untouched; on
+// error the key is left as it was.
+func edit(storage Storage, name string, secret string, options keyOptions) error {
 	ring, err := openKeyring()
 	if err != nil {
 		return fmt.Errorf("cannot open keyring: %w", err)
 	}
-	key := NewKey(ring, name)
-	if digits != nil {
-		switch v := digits.(type) {
-		case int:
-			key.Digits = v
-		case string:
-			key.Digits, err = convertStringToInt(v)
-			if err != nil {
-				return fmt.Errorf("cannot convert string to int: %w", err)
-			}
-		default:
-			return fmt.Errorf("unsupported type for digits: %T", digits)
+	value, err := storage.GetKey(name)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("key %s not found", name)
+	}
+
+	journal := keyringJournal{ring: ring}
+	fail := func(err error) error {
+		if rollbackErr := journal.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w, rollback failed: %s", err, rollbackErr)
 		}
+		return err
 	}
-	if interval != nil {
-		switch v := interval.(type) {
-		case int:
-			key.Interval = v
-		case string:
-			key.Interval, err = convertStringToInt(v)
-			if err != nil {
-				return fmt.Errorf("cannot convert string to int: %w", err)
-			}
-		default:
-			return fmt.Errorf("unsupported type for interval: %T", interval)
+
+	if secret != "" {
+		if err := isValidBase32(secret); err != nil {
+			return fmt.Errorf("secret is not valid: %w", err)
+		}
+		key := Key{Name: name, secret: newSecretString(name, ring)}
+		if err := journal.set(key, secret); err != nil {
+			return fmt.Errorf("cannot set secret for key: %w", err)
 		}
+	}
 
+	if err := updateKey(storage, name, options.apply); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// updateKey changes the stored record of the key named name, leaving its
+// secret untouched.
+func updateKey(storage Storage, name string, change func(key *Key) error) error {
+	return storage.UpdateKey(name, func(value []byte) ([]byte, error) {
+		key := Key{}
+		if err := json.Unmarshal(value, &key); err != nil {
+			return nil, err
+		}
+		if err := change(&key); err != nil {
+			return nil, err
+		}
+		return json.Marshal(key)
+	})
+}
+
+func add(storage Storage, name string, secret string, options keyOptions) error {
+	ring, err := openKeyring()
+	if err != nil {
+		return fmt.Errorf("cannot open keyring: %w", err)
+	}
+	key, err := buildKey(ring, name, secret, options)
+	if err != nil {
+		return err
 	}
 	err = key.Secret(secret)
 	if err != nil {
@@ -350,7 +1000,10 @@ func add(storage Storage, name string, secret string, digits interface{}, interv
 
 type generated struct {
 	Value     string
+	Type      KeyType
 	ExpiresIn int
+	TimeStep  uint64
+	Counter   int
 }
 
 func generate(storage Storage, name string) (generated, error) {
@@ -358,14 +1011,51 @@ func generate(storage Storage, name string) (generated, error) {
 	if err != nil {
 		return generated{}, fmt.Errorf("cannot open keyring: %w", err)
 	}
+	value, err := storage.GetKey(name)
+	if err != nil {
+		return generated{}, err
+	}
+	if value == nil {
+		return generated{}, fmt.Errorf("key %s not found", name)
+	}
 
 	key := KeyFromStorage(storage, ring, name)
+	if key.Type == HOTP_TOKEN {
+		return generateHotp(storage, ring, name)
+	}
 	return generated{
 		Value:     key.GenerateToken(),
+		Type:      key.Type,
 		ExpiresIn: key.ExpiresIn(),
+		TimeStep:  key.TimeStep(),
 	}, nil
 }
 
+// generateHotp generates a token and persists the incremented counter in the
+// same transaction, so the same counter value is never used twice.
+func generateHotp(storage Storage, ring keyring.Keyring, name string) (generated, error) {
+	var token string
+	var counter int
+	err := storage.UpdateKey(name, func(value []byte) ([]byte, error) {
+		key := Key{}
+		if err := json.Unmarshal(value, &key); err != nil {
+			return nil, err
+		}
+		key.secret = newSecretString(name, ring)
+
+		counter = key.Counter
+		token = key.GenerateToken()
+		debugPrint(fmt.Sprintf("HOTP counter for %s is now %d", name, key.Counter))
+
+		return json.Marshal(key)
+	})
+	if err != nil {
+		return generated{}, fmt.Errorf("cannot update HOTP counter: %w", err)
+	}
+
+	return generated{Value: token, Type: HOTP_TOKEN, Counter: counter}, nil
+}
+
 func list(ui cli.Ui, storage Storage) (errors []error) {
 	keys, err := storage.ListKey()
 	if err != nil {
@@ -442,30 +1132,71 @@ func remove(ui cli.Ui, storage Storage, name string) error {
 	return nil
 }
 
-func rename(ui cli.Ui, storage Storage, oldName string, newName string) error {
+// rename moves the key oldName, with its secret, to newName. An existing
+// newName key is overwritten only with force. The secret is copied first and
+// the old one removed only once the record has moved, so that on error the
+// keyring is put back as it was.
+func rename(ui cli.Ui, storage Storage, oldName string, newName string, force bool) error {
 	ring, err := openKeyring()
 	if err != nil {
 		return err
 	}
-	key := KeyFromStorage(storage, ring, oldName)
-	err = key.Rename(newName)
+
+	value, err := storage.GetKey(oldName)
 	if err != nil {
-		ui.Error(fmt.Sprintf("Error renaming key %s: %s", oldName, err))
+		return err
+	}
+	if value == nil {
+		return fmt.Errorf("key %s not found", oldName)
+	}
+	if !force {
+		existing, err := storage.GetKey(newName)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return fmt.Errorf("key %s already exists, use --force to overwrite it", newName)
+		}
+		if _, err := ring.Get(newName); err == nil {
+			return fmt.Errorf("a secret named %s already exists in the keyring, use --force to overwrite it", newName)
+		}
 	}
-	marshal, _ := json.Marshal(key)
-	debugPrint(string(marshal))
 
-	result, err := storage.AddKey(key.Name, []byte(marshal))
+	key := Key{}
+	if err := json.Unmarshal(value, &key); err != nil {
+		return fmt.Errorf("cannot read key %s: %w", oldName, err)
+	}
+	original := newSecretString(oldName, ring)
+	secret, err := original.Value()
 	if err != nil {
 		return err
 	}
-	err = storage.RemoveKey(oldName)
+
+	key.Name = newName
+	key.secret = newSecretString(newName, ring)
+	marshal, err := json.Marshal(key)
 	if err != nil {
-		ui.Error(fmt.Sprintf("Removal of old key failed: %s", err))
+		return err
 	}
-	if !result {
-		ui.Error("something went wrong adding key")
-		os.Exit(1)
+	debugPrint(string(marshal))
+
+	journal := keyringJournal{ring: ring}
+	fail := func(err error) error {
+		if rollbackErr := journal.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w, rollback failed: %s", err, rollbackErr)
+		}
+		return fmt.Errorf("%w, key has not been renamed", err)
+	}
+
+	if err := journal.set(key, string(secret)); err != nil {
+		return fail(fmt.Errorf("cannot copy secret: %w", err))
+	}
+	if err := storage.MoveKey(oldName, newName, marshal); err != nil {
+		return fail(err)
+	}
+
+	if err := original.Remove(); err != nil {
+		ui.Warn(fmt.Sprintf("cannot remove old secret %s from the keyring: %s", oldName, err))
 	}
 	return nil
 }
@@ -488,10 +1219,9 @@ func sanitizeSecret(data string) string {
 }
 
 func isValidBase32(data string) error {
-	encoder := base32.Encoding{}
-	_, err := encoder.DecodeString(data)
-	if err != nil {
-		return err
+	if data == "" {
+		return errors.New("empty string")
 	}
-	return nil
+	_, err := otp.DecodeSecret(data)
+	return err
 }
@@ -4,7 +4,17 @@
 package main
 
 import (
+	"encoding/json"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/endorama/2ami/internal/otp"
 )
 
 func TestIsValidBase32_whitValidData(t *testing.T) {
@@ -37,3 +47,234 @@ func Test_sanitizeSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerate_PersistsHotpCounter(t *testing.T) {
+	ring := useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	secret := "ORSXG5A="
+	key := NewKey(ring, "hotp")
+	key.Type = HOTP_TOKEN
+	require.NoError(t, key.Secret(secret))
+	marshal, _ := json.Marshal(key)
+	_, err := storage.AddKey(key.Name, marshal)
+	require.NoError(t, err)
+
+	const workers, perWorker = 4, 5
+	tokens := make(chan string, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				token, err := generate(storage, key.Name)
+				assert.NoError(t, err)
+				tokens <- token.Value
+			}
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	// every counter value from 1 onwards must have been used exactly once
+	decoded, err := otp.DecodeSecret(secret)
+	require.NoError(t, err)
+	params := otp.Params{Secret: decoded, Digits: key.Digits, Algorithm: key.Algorithm}
+	want := map[string]int{}
+	for c := 1; c <= workers*perWorker; c++ {
+		token, err := otp.HOTP(params, uint64(c))
+		require.NoError(t, err)
+		want[token]++
+	}
+	got := map[string]int{}
+	for token := range tokens {
+		got[token]++
+	}
+	assert.Equal(t, want, got)
+
+	stored := KeyFromStorage(storage, ring, key.Name)
+	assert.Equal(t, 1+workers*perWorker, stored.Counter)
+}
+
+func TestGenerate_NotFound(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	useTestKeyring(t)
+
+	_, err := generate(storage, "nosuch")
+	require.Error(t, err)
+	assert.Equal(t, "key nosuch not found", err.Error())
+}
+
+func TestKeyOptions_Digits(t *testing.T) {
+	tests := []struct {
+		options keyOptions
+		wantErr bool
+	}{
+		{keyOptions{Digits: "6"}, false},
+		{keyOptions{Digits: "10"}, false},
+		{keyOptions{Digits: "4"}, true},
+		{keyOptions{Digits: "11"}, true},
+		{keyOptions{Type: "hotp", Digits: "0"}, true},
+		{keyOptions{Type: "steam"}, false},
+		{keyOptions{Type: "steam", Digits: "5"}, false},
+	}
+
+	for _, tt := range tests {
+		key := NewKey(nil, "test")
+		err := tt.options.apply(&key)
+		if tt.wantErr {
+			require.Error(t, err, "%+v", tt.options)
+			assert.Contains(t, err.Error(), "digits must be between 6 and 10")
+		} else {
+			assert.NoError(t, err, "%+v", tt.options)
+		}
+	}
+
+	// a key no longer steam needs decimal digits
+	key := NewKey(nil, "test")
+	require.NoError(t, keyOptions{Type: "steam"}.apply(&key))
+	assert.Error(t, keyOptions{Type: "totp"}.apply(&key))
+}
+
+func TestRename(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "old", "JBSWY3DPEHPK3PXP", keyOptions{Digits: 8}))
+
+	require.NoError(t, rename(cli.NewMockUi(), storage, "old", "new", false))
+
+	names, err := storage.ListKey()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, names)
+	key := KeyFromStorage(storage, ring, "new")
+	assert.Equal(t, "new", key.Name)
+	assert.Equal(t, 8, key.Digits)
+
+	item, err := ring.Get("new")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(item.Data))
+	_, err = ring.Get("old")
+	assert.ErrorIs(t, err, keyring.ErrKeyNotFound)
+}
+
+func TestRename_Existing(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "old", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	require.NoError(t, add(storage, "new", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+
+	err := rename(cli.NewMockUi(), storage, "old", "new", false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "use --force")
+	item, err := ring.Get("new")
+	require.NoError(t, err)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQ", string(item.Data))
+
+	require.NoError(t, rename(cli.NewMockUi(), storage, "old", "new", true))
+	names, err := storage.ListKey()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new"}, names)
+	item, err = ring.Get("new")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(item.Data))
+}
+
+func TestRename_Rollback(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "old", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	require.NoError(t, add(storage, "new", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+
+	// the record move fails as the database is closed once the secret is copied
+	openKeyring = func() (keyring.Keyring, error) {
+		return hookedKeyring{Keyring: ring, beforeSet: func(keyring.Item) error {
+			return storage.Close()
+		}}, nil
+	}
+	err := rename(cli.NewMockUi(), storage, "old", "new", true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "key has not been renamed")
+
+	for name, want := range map[string]string{"old": "JBSWY3DPEHPK3PXP", "new": "GEZDGNBVGY3TQOJQ"} {
+		item, err := ring.Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(item.Data))
+	}
+}
+
+func TestEdit(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "test", "JBSWY3DPEHPK3PXP", keyOptions{Interval: "60"}))
+
+	require.NoError(t, edit(storage, "test", "", keyOptions{Digits: "8", Issuer: "Example", Account: "alice"}))
+
+	key := KeyFromStorage(storage, ring, "test")
+	assert.Equal(t, 8, key.Digits)
+	assert.Equal(t, 60, key.Interval)
+	assert.Equal(t, "Example", key.Issuer)
+	assert.Equal(t, "alice", key.Account)
+	item, err := ring.Get("test")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(item.Data))
+}
+
+func TestEdit_Secret(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "test", "JBSWY3DPEHPK3PXP", keyOptions{Digits: "8"}))
+
+	ui := cli.NewMockUi()
+	ui.InputReader = strings.NewReader("gezd gnbv gy3t qojq\n")
+	require.NoError(t, editWithPrompt(ui, storage, "test", keyOptions{}, true))
+
+	item, err := ring.Get("test")
+	require.NoError(t, err)
+	assert.Equal(t, "GEZDGNBVGY3TQOJQ", string(item.Data))
+	assert.Equal(t, 8, KeyFromStorage(storage, ring, "test").Digits)
+}
+
+func TestEdit_Invalid(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "test", "JBSWY3DPEHPK3PXP", keyOptions{}))
+
+	err := edit(storage, "missing", "", keyOptions{Digits: "8"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "key missing not found")
+
+	err = edit(storage, "test", "not base32!", keyOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "secret is not valid")
+
+	// the new secret is rolled back when the settings cannot be applied
+	err = edit(storage, "test", "GEZDGNBVGY3TQOJQ", keyOptions{Interval: "0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "interval must be greater than zero")
+
+	item, err := ring.Get("test")
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(item.Data))
+	assert.Equal(t, 30, KeyFromStorage(storage, ring, "test").Interval)
+}
+
+func TestIsValidBase32_whitUnpaddedData(t *testing.T) {
+	if isValidBase32("4SJHB4GSD43FZBAI7C2HLRJGPQ") != nil {
+		t.Error("Not a valid Base32 string")
+	}
+}
+
+func TestIsValidBase32_whitEmptyData(t *testing.T) {
+	if isValidBase32("") == nil {
+		t.Error("base32 validator accepted an empty string")
+	}
+}
@@ -0,0 +1,225 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/endorama/2ami/internal/migration"
+	"github.com/endorama/2ami/internal/otp"
+)
+
+// migrationBatchSize is the number of keys per migration URI, as Google
+// Authenticator does to keep each QR code readable.
+const migrationBatchSize = 10
+
+// migrationKeys converts the entries of a Google Authenticator migration
+// payload to keys ready to be added.
+func migrationKeys(payload *migration.Payload) ([]importedKey, error) {
+	keys := make([]importedKey, 0, len(payload.OtpParameters))
+	for _, params := range payload.OtpParameters {
+		key, err := migrationKey(params)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import %s: %w", params.Name, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func migrationKey(params migration.OtpParameters) (importedKey, error) {
+	if len(params.Secret) == 0 {
+		return importedKey{}, fmt.Errorf("entry has no secret")
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(params.Secret)
+
+	// names are usually labels, as in otpauth:// URIs
+	issuer, account := splitOtpauthLabel(params.Name)
+	if params.Issuer != "" {
+		issuer = params.Issuer
+	}
+	if account == "" {
+		return importedKey{}, fmt.Errorf("entry has no account name")
+	}
+
+	options := keyOptions{
+		Account: account,
+		Digits:  params.Digits.Count(),
+	}
+	if issuer != "" {
+		options.Issuer = issuer
+	}
+
+	switch params.Algorithm {
+	case migration.AlgorithmUnspecified, migration.AlgorithmSHA1:
+		options.Algorithm = SHA1_ALGORITHM
+	case migration.AlgorithmSHA256:
+		options.Algorithm = SHA256_ALGORITHM
+	case migration.AlgorithmSHA512:
+		options.Algorithm = SHA512_ALGORITHM
+	default:
+		return importedKey{}, fmt.Errorf("unsupported algorithm: %d", params.Algorithm)
+	}
+
+	switch params.Type {
+	case migration.OtpTypeHOTP:
+		options.Type = HOTP_TOKEN
+		options.Counter = int(params.Counter)
+	case migration.OtpTypeUnspecified, migration.OtpTypeTOTP:
+		options.Type = TOTP_TOKEN
+	default:
+		return importedKey{}, fmt.Errorf("unsupported type: %d", params.Type)
+	}
+
+	return importedKey{
+		Name:    otpauthKeyName(issuer, account),
+		Secret:  secret,
+		Options: options,
+	}, nil
+}
+
+// keysFromURI returns the keys in either an otpauth:// or a Google
+// Authenticator otpauth-migration:// URI.
+func keysFromURI(uri string) ([]importedKey, error) {
+	if strings.HasPrefix(strings.TrimSpace(uri), migration.Scheme+":") {
+		payload, err := migration.ParseURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		return migrationKeys(payload)
+	}
+
+	key, err := parseOtpauthURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return []importedKey{key}, nil
+}
+
+// readGoogleMigration returns the keys in otpauth-migration:// URIs, one
+// per line as produced by `2ami export --format=google-migration`.
+func readGoogleMigration(input string) ([]importedKey, []skippedKey, error) {
+	lines, err := readOtpauthURIs(strings.NewReader(input))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil, fmt.Errorf("no migration URI found")
+	}
+
+	var keys []importedKey
+	var skipped []skippedKey
+	for _, line := range lines {
+		payload, err := migration.ParseURI(line.URI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", line.Number, err)
+		}
+		for _, params := range payload.OtpParameters {
+			key, err := migrationKey(params)
+			if err != nil {
+				skipped = append(skipped, skippedKey{Name: params.Name, Reason: err.Error()})
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, skipped, nil
+}
+
+// exportGoogleMigration returns the named keys as otpauth-migration:// URIs.
+func exportGoogleMigration(storage Storage, names []string) ([]string, error) {
+	ring, err := openKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open keyring: %w", err)
+	}
+
+	entries := make([]migration.OtpParameters, 0, len(names))
+	for _, name := range names {
+		value, err := storage.GetKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, fmt.Errorf("key %s not found", name)
+		}
+
+		key := KeyFromStorage(storage, ring, name)
+		params, err := migrationParameters(key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot export %s: %w", name, err)
+		}
+		entries = append(entries, params)
+	}
+
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	batchID := int32(binary.BigEndian.Uint32(id[:]))
+
+	var uris []string
+	for _, payload := range migration.Batches(entries, migrationBatchSize, batchID) {
+		uris = append(uris, payload.URI())
+	}
+	return uris, nil
+}
+
+// migrationParameters converts a key to a migration entry. The format has no
+// period, so only keys using Google Authenticator defaults can be exported.
+func migrationParameters(key Key) (migration.OtpParameters, error) {
+	value, err := key.secret.Value()
+	if err != nil {
+		return migration.OtpParameters{}, err
+	}
+	secret, err := otp.DecodeSecret(string(value))
+	if err != nil {
+		return migration.OtpParameters{}, err
+	}
+
+	params := migration.OtpParameters{
+		Secret: secret,
+		Name:   key.otpauthLabel(),
+		Issuer: key.Issuer,
+	}
+
+	switch key.Algorithm {
+	case SHA1_ALGORITHM, "":
+		params.Algorithm = migration.AlgorithmSHA1
+	case SHA256_ALGORITHM:
+		params.Algorithm = migration.AlgorithmSHA256
+	case SHA512_ALGORITHM:
+		params.Algorithm = migration.AlgorithmSHA512
+	default:
+		return migration.OtpParameters{}, fmt.Errorf("unsupported algorithm: %s", key.Algorithm)
+	}
+
+	switch key.Digits {
+	case 6:
+		params.Digits = migration.DigitCountSix
+	case 8:
+		params.Digits = migration.DigitCountEight
+	default:
+		return migration.OtpParameters{}, fmt.Errorf("only 6 or 8 digits are supported, key has %d", key.Digits)
+	}
+
+	switch key.Type {
+	case TOTP_TOKEN:
+		if key.Interval != 30 || key.T0 != 0 {
+			return migration.OtpParameters{}, fmt.Errorf("only 30 seconds periods from the Unix epoch are supported")
+		}
+		params.Type = migration.OtpTypeTOTP
+	case HOTP_TOKEN:
+		params.Type = migration.OtpTypeHOTP
+		params.Counter = int64(key.Counter)
+	default:
+		return migration.OtpParameters{}, fmt.Errorf("unsupported key type: %s", key.Type)
+	}
+
+	return params, nil
+}
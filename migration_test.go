@@ -0,0 +1,163 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/endorama/2ami/internal/migration"
+)
+
+func TestMigrationKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  migration.OtpParameters
+		want    importedKey
+		wantErr bool
+	}{
+		{
+			name: "totp with label",
+			params: migration.OtpParameters{
+				Secret: []byte("Hello!\xde\xad\xbe\xef"),
+				Name:   "Example:alice@google.com",
+				Issuer: "Example",
+				Type:   migration.OtpTypeTOTP,
+			},
+			want: importedKey{
+				Name:   "Example - alice@google.com",
+				Secret: "JBSWY3DPEHPK3PXP",
+				Options: keyOptions{
+					Type:      TOTP_TOKEN,
+					Issuer:    "Example",
+					Account:   "alice@google.com",
+					Digits:    6,
+					Algorithm: SHA1_ALGORITHM,
+				},
+			},
+		},
+		{
+			name: "hotp with eight digits and sha256",
+			params: migration.OtpParameters{
+				Secret:    []byte("Hello!\xde\xad\xbe\xef"),
+				Name:      "bob",
+				Algorithm: migration.AlgorithmSHA256,
+				Digits:    migration.DigitCountEight,
+				Type:      migration.OtpTypeHOTP,
+				Counter:   42,
+			},
+			want: importedKey{
+				Name:   "bob",
+				Secret: "JBSWY3DPEHPK3PXP",
+				Options: keyOptions{
+					Type:      HOTP_TOKEN,
+					Account:   "bob",
+					Digits:    8,
+					Counter:   42,
+					Algorithm: SHA256_ALGORITHM,
+				},
+			},
+		},
+		{
+			name:    "md5",
+			params:  migration.OtpParameters{Secret: []byte("x"), Name: "a", Algorithm: migration.AlgorithmMD5},
+			wantErr: true,
+		},
+		{
+			name:    "no secret",
+			params:  migration.OtpParameters{Name: "a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := migrationKey(tt.params)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestKeysFromURI(t *testing.T) {
+	keys, err := keysFromURI("otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	keys, err = keysFromURI("otpauth-migration://offline?data=CjEKCkhlbGxvId6tvu8SGEV4YW1wbGU6YWxpY2VAZ29vZ2xlLmNvbRoHRXhhbXBsZTAC")
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "Example - alice@google.com", keys[0].Name)
+
+	_, err = keysFromURI("https://example.com")
+	assert.Error(t, err)
+}
+
+func TestGoogleMigrationRoundTrip(t *testing.T) {
+	ring := useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	require.NoError(t, add(storage, "ACME - alice", "JBSWY3DPEHPK3PXP", keyOptions{
+		Issuer: "ACME", Account: "alice", Algorithm: "SHA256", Digits: 8,
+	}))
+	require.NoError(t, add(storage, "bob", "ORSXG5A=", keyOptions{
+		Type: HOTP_TOKEN, Account: "bob", Counter: 42,
+	}))
+
+	uris, err := exportGoogleMigration(storage, []string{"ACME - alice", "bob"})
+	require.NoError(t, err)
+	require.Len(t, uris, 1)
+
+	target, cleanupTarget := setupTestStorage(t)
+	defer cleanupTarget()
+	// the keyring is shared, restoring overwrites the same secrets
+	result, err := restore(cli.NewMockUi(), target, strings.Join(uris, "\n"), "", backupFormatGoogleMigration, restoreOptions{OnConflict: conflictOverwrite})
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed)
+
+	alice := KeyFromStorage(target, ring, "ACME - alice")
+	assert.Equal(t, SHA256_ALGORITHM, alice.Algorithm)
+	assert.Equal(t, 8, alice.Digits)
+	assert.Equal(t, "ACME", alice.Issuer)
+	assert.Equal(t, "alice", alice.Account)
+
+	bob := KeyFromStorage(target, ring, "bob")
+	assert.Equal(t, HOTP_TOKEN, bob.Type)
+	assert.Equal(t, 42, bob.Counter)
+	secret, err := bob.secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "ORSXG5A", string(secret))
+}
+
+func TestMigrationParameters_Unsupported(t *testing.T) {
+	ring := useTestKeyring(t)
+
+	keys := []Key{
+		{Name: "digits", Type: TOTP_TOKEN, Digits: 7, Interval: 30},
+		{Name: "period", Type: TOTP_TOKEN, Digits: 6, Interval: 60},
+		{Name: "steam", Type: STEAM_TOKEN, Digits: 5, Interval: 30},
+	}
+	for _, key := range keys {
+		key.secret = newSecretString(key.Name, ring)
+		require.NoError(t, key.Secret("JBSWY3DPEHPK3PXP"))
+
+		_, err := migrationParameters(key)
+		assert.Error(t, err, key.Name)
+	}
+}
+
+func TestRestoreGoogleMigration_Empty(t *testing.T) {
+	storage := NewStorage("/tmp", "test.db")
+
+	_, err := restore(cli.NewMockUi(), storage, "# nothing here\n", "", backupFormatGoogleMigration, restoreOptions{OnConflict: conflictOverwrite})
+	assert.Error(t, err)
+}
@@ -5,10 +5,14 @@ package main
 
 import (
 	"encoding/base32"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/mitchellh/cli"
 )
 
 func convertStringToInt(value string) (returnValue int, err error) {
@@ -39,3 +43,16 @@ func printErrorsAndExit(errors []error) {
 		os.Exit(1) // skipcq: RVV-A0003
 	}
 }
+
+// confirmSecretExposure asks the user to confirm an operation that reveals
+// secrets, returning an error unless the answer is yes.
+func confirmSecretExposure(ui cli.Ui, what string) error {
+	answer, err := ui.Ask(fmt.Sprintf("This will reveal %s in clear text. Continue? [yes/no]: ", what))
+	if err != nil {
+		return fmt.Errorf("cannot read confirmation: %w", err)
+	}
+	if strings.ToLower(strings.TrimSpace(answer)) != "yes" {
+		return errors.New("aborted by user")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// parseOtpauthURI decodes an otpauth:// URI as described in
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func parseOtpauthURI(raw string) (importedKey, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return importedKey{}, fmt.Errorf("cannot parse URI: %w", err)
+	}
+	if u.Scheme != "otpauth" {
+		return importedKey{}, fmt.Errorf("unsupported URI scheme: %s", u.Scheme)
+	}
+
+	keyType, err := keyTypeFromURI(u)
+	if err != nil {
+		return importedKey{}, err
+	}
+
+	q := u.Query()
+	secret := sanitizeSecret(q.Get("secret"))
+	if secret == "" {
+		return importedKey{}, fmt.Errorf("URI has no secret")
+	}
+	if err := isValidBase32(secret); err != nil {
+		return importedKey{}, fmt.Errorf("secret is not valid: %w", err)
+	}
+
+	issuer, account := splitOtpauthLabel(strings.TrimPrefix(u.Path, "/"))
+	// the issuer parameter is the recommended one, the label prefix is legacy
+	if q.Get("issuer") != "" {
+		issuer = q.Get("issuer")
+	}
+	if account == "" {
+		return importedKey{}, fmt.Errorf("URI has no account name")
+	}
+
+	options := keyOptions{Type: keyType, Account: account}
+	if issuer != "" {
+		options.Issuer = issuer
+	}
+	if v := q.Get("digits"); v != "" {
+		options.Digits = v
+	}
+	if v := q.Get("period"); v != "" {
+		options.Interval = v
+	}
+	if v := q.Get("algorithm"); v != "" {
+		options.Algorithm = v
+	}
+	if keyType == HOTP_TOKEN {
+		if q.Get("counter") == "" {
+			return importedKey{}, fmt.Errorf("hotp URI has no counter")
+		}
+		options.Counter = q.Get("counter")
+	}
+
+	return importedKey{
+		Name:    otpauthKeyName(issuer, account),
+		Secret:  secret,
+		Options: options,
+	}, nil
+}
+
+// splitOtpauthLabel splits an "Issuer:account" label, the issuer is optional.
+func splitOtpauthLabel(label string) (issuer, account string) {
+	parts := strings.SplitN(label, ":", 2)
+	if len(parts) == 1 {
+		return "", strings.TrimSpace(parts[0])
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// otpauthKeyName returns the name a key is stored with, following the same
+// convention used when importing from other authenticators.
+func otpauthKeyName(issuer, account string) string {
+	if issuer == "" {
+		return account
+	}
+	return issuer + " - " + account
+}
+
+// otpauthLine is an URI read from a file, with its line number.
+type otpauthLine struct {
+	Number int
+	URI    string
+}
+
+// readOtpauthURIs returns the URIs in r, one per line. Empty lines and lines
+// starting with # are ignored.
+func readOtpauthURIs(r io.Reader) ([]otpauthLine, error) {
+	var lines []otpauthLine
+	scanner := bufio.NewScanner(r)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		lines = append(lines, otpauthLine{Number: number, URI: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// exportURIs returns the otpauth:// URI of each named key.
+func exportURIs(storage Storage, names []string) ([]string, error) {
+	ring, err := openKeyring()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open keyring: %w", err)
+	}
+
+	uris := make([]string, 0, len(names))
+	for _, name := range names {
+		value, err := storage.GetKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, fmt.Errorf("key %s not found", name)
+		}
+
+		key := KeyFromStorage(storage, ring, name)
+		uri, err := key.OtpauthURI()
+		if err != nil {
+			return nil, fmt.Errorf("cannot export %s: %w", name, err)
+		}
+		uris = append(uris, uri)
+	}
+
+	return uris, nil
+}
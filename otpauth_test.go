@@ -0,0 +1,169 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOtpauthURI(t *testing.T) {
+	tests := []struct {
+		name    string
+		uri     string
+		want    importedKey
+		wantErr bool
+	}{
+		{
+			name: "totp with issuer parameter",
+			uri:  "otpauth://totp/ACME%20Co:john.doe@email.com?secret=HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ&issuer=ACME%20Co&algorithm=SHA256&digits=8&period=60",
+			want: importedKey{
+				Name:   "ACME Co - john.doe@email.com",
+				Secret: "HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ",
+				Options: keyOptions{
+					Type:      TOTP_TOKEN,
+					Issuer:    "ACME Co",
+					Account:   "john.doe@email.com",
+					Digits:    "8",
+					Interval:  "60",
+					Algorithm: "SHA256",
+				},
+			},
+		},
+		{
+			name: "issuer only in label",
+			uri:  "otpauth://totp/Example:%20alice@google.com?secret=jbswy3dpehpk3pxp",
+			want: importedKey{
+				Name:    "Example - alice@google.com",
+				Secret:  "JBSWY3DPEHPK3PXP",
+				Options: keyOptions{Type: TOTP_TOKEN, Issuer: "Example", Account: "alice@google.com"},
+			},
+		},
+		{
+			name: "no issuer",
+			uri:  "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP",
+			want: importedKey{
+				Name:    "alice",
+				Secret:  "JBSWY3DPEHPK3PXP",
+				Options: keyOptions{Type: TOTP_TOKEN, Account: "alice"},
+			},
+		},
+		{
+			name: "hotp",
+			uri:  "otpauth://hotp/bob?secret=JBSWY3DPEHPK3PXP&counter=42",
+			want: importedKey{
+				Name:    "bob",
+				Secret:  "JBSWY3DPEHPK3PXP",
+				Options: keyOptions{Type: HOTP_TOKEN, Account: "bob", Counter: "42"},
+			},
+		},
+		{
+			name: "steam",
+			uri:  "otpauth://totp/Steam:carol?secret=JBSWY3DPEHPK3PXP&encoder=steam",
+			want: importedKey{
+				Name:    "Steam - carol",
+				Secret:  "JBSWY3DPEHPK3PXP",
+				Options: keyOptions{Type: STEAM_TOKEN, Issuer: "Steam", Account: "carol"},
+			},
+		},
+		{name: "hotp without counter", uri: "otpauth://hotp/bob?secret=JBSWY3DPEHPK3PXP", wantErr: true},
+		{name: "missing secret", uri: "otpauth://totp/alice", wantErr: true},
+		{name: "invalid secret", uri: "otpauth://totp/alice?secret=1234", wantErr: true},
+		{name: "missing account", uri: "otpauth://totp/?secret=JBSWY3DPEHPK3PXP", wantErr: true},
+		{name: "wrong scheme", uri: "https://totp/alice?secret=JBSWY3DPEHPK3PXP", wantErr: true},
+		{name: "unknown type", uri: "otpauth://motp/alice?secret=JBSWY3DPEHPK3PXP", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOtpauthURI(tt.uri)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestReadOtpauthURIs(t *testing.T) {
+	input := "# exported keys\notpauth://totp/a?secret=A\n\n  otpauth://totp/b?secret=B  \n"
+
+	lines, err := readOtpauthURIs(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []otpauthLine{
+		{Number: 2, URI: "otpauth://totp/a?secret=A"},
+		{Number: 4, URI: "otpauth://totp/b?secret=B"},
+	}, lines)
+}
+
+func TestImportURIs(t *testing.T) {
+	ring := useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	path := filepath.Join(t.TempDir(), "uris.txt")
+	content := "otpauth://totp/ACME:alice?secret=JBSWY3DPEHPK3PXP&digits=8\n" +
+		"otpauth://hotp/bob?secret=JBSWY3DPEHPK3PXP\n" +
+		"otpauth://hotp/carol?secret=JBSWY3DPEHPK3PXP&counter=7\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	errors := importURIs(cli.NewMockUi(), storage, path)
+	require.Len(t, errors, 1)
+	assert.Contains(t, errors[0].Error(), "line 2")
+
+	keys, err := storage.ListKey()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ACME - alice", "carol"}, keys)
+
+	alice := KeyFromStorage(storage, ring, "ACME - alice")
+	assert.Equal(t, 8, alice.Digits)
+	assert.Equal(t, "ACME", alice.Issuer)
+	assert.Equal(t, "alice", alice.Account)
+
+	carol := KeyFromStorage(storage, ring, "carol")
+	assert.Equal(t, HOTP_TOKEN, carol.Type)
+	assert.Equal(t, 7, carol.Counter)
+}
+
+func TestExportURIs(t *testing.T) {
+	useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	uris := []string{
+		"otpauth://totp/ACME%20Co:john.doe@email.com?algorithm=SHA256&digits=8&issuer=ACME%20Co&period=60&secret=HXDMVJECJJWSRB3HWIZR4IFUGFTMXBOZ",
+		"otpauth://hotp/bob?counter=42&digits=6&secret=JBSWY3DPEHPK3PXP",
+	}
+	var names []string
+	for _, uri := range uris {
+		key, err := parseOtpauthURI(uri)
+		require.NoError(t, err)
+		require.NoError(t, add(storage, key.Name, key.Secret, key.Options))
+		names = append(names, key.Name)
+	}
+
+	got, err := exportURIs(storage, names)
+	require.NoError(t, err)
+	assert.Equal(t, uris, got)
+
+	_, err = exportURIs(storage, []string{"missing"})
+	assert.Error(t, err)
+}
+
+func TestConfirmSecretExposure(t *testing.T) {
+	ui := cli.NewMockUi()
+	ui.InputReader = strings.NewReader("yes\n")
+	assert.NoError(t, confirmSecretExposure(ui, "secrets"))
+
+	ui = cli.NewMockUi()
+	ui.InputReader = strings.NewReader("y\n")
+	assert.Error(t, confirmSecretExposure(ui, "secrets"))
+}
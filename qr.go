@@ -0,0 +1,116 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg" // register JPEG decoding for QR code images
+	_ "image/png"  // register PNG decoding for QR code images
+	"os"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqr "github.com/makiuchi-d/gozxing/qrcode"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// qrPNGSize is the width and height, in pixels, of PNG QR codes.
+	qrPNGSize = 512
+	// qrPNGPerm keeps PNG QR codes private, as they contain the secret.
+	qrPNGPerm = 0600
+)
+
+// keyQRCode returns a QR code encoding the otpauth:// URI of the named key.
+func keyQRCode(storage Storage, name string) (*qrcode.QRCode, error) {
+	uris, err := exportURIs(storage, []string{name})
+	if err != nil {
+		return nil, err
+	}
+
+	qr, err := qrcode.New(uris[0], qrcode.Medium)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode QR code: %w", err)
+	}
+	return qr, nil
+}
+
+// writeQRCodePNG writes qr as a PNG image at path.
+func writeQRCodePNG(qr *qrcode.QRCode, path string) error {
+	data, err := qr.PNG(qrPNGSize)
+	if err != nil {
+		return fmt.Errorf("cannot render PNG: %w", err)
+	}
+	return os.WriteFile(path, data, qrPNGPerm)
+}
+
+// renderQRCode renders qr for the terminal. Each character draws two modules
+// with the upper half block, colors are set explicitly with ANSI escapes so
+// the code scans on both dark and light terminal themes.
+func renderQRCode(qr *qrcode.QRCode) string {
+	const (
+		fgBlack, fgWhite = "\x1b[30m", "\x1b[97m"
+		bgBlack, bgWhite = "\x1b[40m", "\x1b[107m"
+		reset            = "\x1b[0m"
+	)
+
+	bits := qr.Bitmap()
+	var b strings.Builder
+	for y := 0; y < len(bits); y += 2 {
+		for x := range bits[y] {
+			top := bits[y][x]
+			// the last row of an odd sized code has no lower half
+			bottom := false
+			if y+1 < len(bits) {
+				bottom = bits[y+1][x]
+			}
+
+			if top {
+				b.WriteString(fgBlack)
+			} else {
+				b.WriteString(fgWhite)
+			}
+			if bottom {
+				b.WriteString(bgBlack)
+			} else {
+				b.WriteString(bgWhite)
+			}
+			b.WriteString("▀")
+		}
+		b.WriteString(reset)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// decodeQRCodeImage returns the text of the QR code in the PNG or JPEG image
+// at path.
+func decodeQRCodeImage(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot open image: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode image: %w", err)
+	}
+
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return "", fmt.Errorf("cannot read image: %w", err)
+	}
+
+	// screenshots are rarely cropped to the code, look harder for it
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+	result, err := zxingqr.NewQRCodeReader().Decode(bitmap, hints)
+	if err != nil {
+		return "", fmt.Errorf("cannot find a QR code in image: %w", err)
+	}
+	return result.GetText(), nil
+}
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyQRCode(t *testing.T) {
+	useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	require.NoError(t, add(storage, "test", "JBSWY3DPEHPK3PXP", keyOptions{}))
+
+	qr, err := keyQRCode(storage, "test")
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth://totp/test?digits=6&period=30&secret=JBSWY3DPEHPK3PXP", qr.Content)
+
+	// two modules per line, the last one alone when the size is odd
+	bits := qr.Bitmap()
+	rendered := renderQRCode(qr)
+	assert.Equal(t, (len(bits)+1)/2, strings.Count(rendered, "\n"))
+	assert.Equal(t, (len(bits)+1)/2*len(bits), strings.Count(rendered, "▀"))
+
+	path := filepath.Join(t.TempDir(), "qr.png")
+	require.NoError(t, writeQRCodePNG(qr, path))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(qrPNGPerm), info.Mode().Perm())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	img, err := png.Decode(file)
+	require.NoError(t, err)
+	assert.Equal(t, qrPNGSize, img.Bounds().Dx())
+
+	_, err = keyQRCode(storage, "missing")
+	assert.Error(t, err)
+}
+
+func TestDecodeQRCodeImage(t *testing.T) {
+	useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	require.NoError(t, add(storage, "test", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	qr, err := keyQRCode(storage, "test")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "qr.png")
+	require.NoError(t, writeQRCodePNG(qr, path))
+
+	text, err := decodeQRCodeImage(path)
+	require.NoError(t, err)
+	assert.Equal(t, qr.Content, text)
+
+	_, err = decodeQRCodeImage(filepath.Join(t.TempDir(), "missing.png"))
+	assert.Error(t, err)
+}
+
+func TestAddFromQRCode_Migration(t *testing.T) {
+	ring := useTestKeyring(t)
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	uri := "otpauth-migration://offline?data=CjEKCkhlbGxvId6tvu8SGEV4YW1wbGU6YWxpY2VAZ29vZ2xlLmNvbRoHRXhhbXBsZTAC"
+	path := filepath.Join(t.TempDir(), "migration.png")
+	require.NoError(t, qrcode.WriteFile(uri, qrcode.Medium, 512, path))
+
+	errors := addFromQRCode(cli.NewMockUi(), storage, path)
+	require.Empty(t, errors)
+
+	key := KeyFromStorage(storage, ring, "Example - alice@google.com")
+	assert.Equal(t, "Example", key.Issuer)
+	assert.Equal(t, "alice@google.com", key.Account)
+	secret, err := key.secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(secret))
+}
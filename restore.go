@@ -0,0 +1,460 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+)
+
+// importedKey is a key read from a URI or a backup, ready to be added.
+type importedKey struct {
+	Name    string
+	Secret  string
+	Options keyOptions
+}
+
+// conflictPolicy decides what to do with a restored key whose name is
+// already taken.
+type conflictPolicy string
+
+const (
+	conflictSkip      conflictPolicy = "skip"
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictRename    conflictPolicy = "rename"
+	conflictAsk       conflictPolicy = "ask"
+)
+
+func parseConflictPolicy(name string) (conflictPolicy, error) {
+	switch policy := conflictPolicy(strings.ToLower(name)); policy {
+	case conflictSkip, conflictOverwrite, conflictRename, conflictAsk:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unsupported conflict policy: %s", name)
+	}
+}
+
+type restoreOptions struct {
+	// DryRun only prints what would be restored.
+	DryRun     bool
+	OnConflict conflictPolicy
+	// Only restores the keys with these names.
+	Only []string
+	// Match restores the keys whose name matches this glob.
+	Match string
+}
+
+// selected reports whether the key named name has to be restored; with no
+// filter set all keys are.
+func (o restoreOptions) selected(name string) (bool, error) {
+	if len(o.Only) == 0 && o.Match == "" {
+		return true, nil
+	}
+	for _, only := range o.Only {
+		if name == only {
+			return true, nil
+		}
+	}
+	if o.Match != "" {
+		matched, err := path.Match(o.Match, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid glob %s: %w", o.Match, err)
+		}
+		return matched, nil
+	}
+	return false, nil
+}
+
+// skippedKey is a key that restore did not add, and why.
+type skippedKey struct {
+	Name   string
+	Reason string
+}
+
+// failedKey is the key that made restore fail.
+type failedKey struct {
+	Name string
+	Err  error
+}
+
+// restoreResult reports what restore did with each key of a backup.
+type restoreResult struct {
+	Imported []string
+	Skipped  []skippedKey
+	Failed   []failedKey
+}
+
+// restoreAction is what restore does with a key.
+type restoreAction string
+
+const (
+	restoreAdd       restoreAction = "add"
+	restoreOverwrite restoreAction = "overwrite"
+	restoreRename    restoreAction = "rename"
+	restoreSkip      restoreAction = "skip"
+	// restoreAsk is only planned in dry runs, where nobody is asked.
+	restoreAsk restoreAction = "ask"
+)
+
+type plannedKey struct {
+	importedKey
+	Action restoreAction
+	// Target is the name the key is stored with.
+	Target string
+	// Reason why the key is skipped.
+	Reason string
+}
+
+// restore adds the keys in a backup, resolving conflicts with existing keys
+// as set in options. Either all keys are added or, on error, none is.
+func restore(ui cli.Ui, storage Storage, input string, password string, format string, options restoreOptions) (restoreResult, error) {
+	keys, skipped, err := readSelectedKeys(input, password, format, options)
+	if err != nil {
+		return restoreResult{}, err
+	}
+
+	plan, err := planRestore(ui, storage, keys, options)
+	if err != nil {
+		return restoreResult{}, err
+	}
+	for _, key := range skipped {
+		plan = append(plan, plannedKey{
+			importedKey: importedKey{Name: key.Name},
+			Action:      restoreSkip,
+			Target:      key.Name,
+			Reason:      key.Reason,
+		})
+	}
+
+	if options.DryRun {
+		printRestorePlan(ui, plan)
+		return restoreResult{}, nil
+	}
+
+	// Ctrl-C must not leave the vault half restored
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	return applyRestore(storage, plan, interrupt)
+}
+
+// listBackup returns the names of the keys in a backup, selected by options.
+func listBackup(input string, password string, format string, options restoreOptions) ([]string, error) {
+	keys, skipped, err := readSelectedKeys(input, password, format, options)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys)+len(skipped))
+	for _, key := range keys {
+		names = append(names, key.Name)
+	}
+	for _, key := range skipped {
+		names = append(names, key.Name)
+	}
+	return names, nil
+}
+
+// readSelectedKeys reads a backup keeping only the keys selected by options.
+// Names passed to Only must all be in the backup.
+func readSelectedKeys(input string, password string, format string, options restoreOptions) ([]importedKey, []skippedKey, error) {
+	keys, skipped, err := readBackup(input, password, format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[string]bool, len(keys)+len(skipped))
+	selectedKeys := make([]importedKey, 0, len(keys))
+	for _, key := range keys {
+		found[key.Name] = true
+		ok, err := options.selected(key.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			selectedKeys = append(selectedKeys, key)
+		}
+	}
+	var selectedSkipped []skippedKey
+	for _, key := range skipped {
+		found[key.Name] = true
+		ok, err := options.selected(key.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			selectedSkipped = append(selectedSkipped, key)
+		}
+	}
+
+	var missing []string
+	for _, name := range options.Only {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("keys not found in backup: %s", strings.Join(missing, ", "))
+	}
+
+	return selectedKeys, selectedSkipped, nil
+}
+
+// planRestore decides the action for each key, asking ui when the policy is
+// conflictAsk.
+func planRestore(ui cli.Ui, storage Storage, keys []importedKey, options restoreOptions) ([]plannedKey, error) {
+	names, err := storage.ListKey()
+	if err != nil {
+		return nil, err
+	}
+	// used holds the names of the vault once the keys planned so far are in,
+	// taken also those of the keys still to come, not to rename onto them
+	used := make(map[string]bool, len(names))
+	taken := make(map[string]bool, len(names)+len(keys))
+	for _, name := range names {
+		used[name] = true
+		taken[name] = true
+	}
+	for _, key := range keys {
+		taken[key.Name] = true
+	}
+
+	plan := make([]plannedKey, 0, len(keys))
+	for _, key := range keys {
+		planned := plannedKey{importedKey: key, Action: restoreAdd, Target: key.Name}
+
+		if used[key.Name] {
+			planned.Action, err = conflictAction(ui, key.Name, options)
+			if err != nil {
+				return nil, err
+			}
+		}
+		switch planned.Action {
+		case restoreRename:
+			planned.Target = freeKeyName(key.Name, taken)
+		case restoreSkip, restoreAsk:
+			planned.Reason = "already exists"
+		}
+
+		if planned.Action != restoreSkip {
+			used[planned.Target] = true
+			taken[planned.Target] = true
+		}
+		plan = append(plan, planned)
+	}
+
+	return plan, nil
+}
+
+func conflictAction(ui cli.Ui, name string, options restoreOptions) (restoreAction, error) {
+	switch options.OnConflict {
+	case conflictSkip:
+		return restoreSkip, nil
+	case conflictOverwrite:
+		return restoreOverwrite, nil
+	case conflictRename:
+		return restoreRename, nil
+	case conflictAsk:
+		if options.DryRun {
+			return restoreAsk, nil
+		}
+		return askConflictAction(ui, name)
+	default:
+		return "", fmt.Errorf("unsupported conflict policy: %s", options.OnConflict)
+	}
+}
+
+func askConflictAction(ui cli.Ui, name string) (restoreAction, error) {
+	for {
+		answer, err := ui.Ask(fmt.Sprintf("Key %s already exists. Overwrite, skip or rename? [overwrite/skip/rename]: ", name))
+		if err != nil {
+			return "", fmt.Errorf("cannot read answer: %w", err)
+		}
+		switch action := restoreAction(strings.ToLower(strings.TrimSpace(answer))); action {
+		case restoreOverwrite, restoreSkip, restoreRename:
+			return action, nil
+		}
+	}
+}
+
+// freeKeyName returns the first "name (n)" that is not taken.
+func freeKeyName(name string, taken map[string]bool) string {
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		if !taken[candidate] {
+			return candidate
+		}
+	}
+}
+
+func printRestorePlan(ui cli.Ui, plan []plannedKey) {
+	for _, key := range plan {
+		switch {
+		case key.Action == restoreRename:
+			ui.Output(fmt.Sprintf("%-9s %s -> %s", key.Action, key.Name, key.Target))
+		case key.Reason != "":
+			ui.Output(fmt.Sprintf("%-9s %s (%s)", key.Action, key.Name, key.Reason))
+		default:
+			ui.Output(fmt.Sprintf("%-9s %s", key.Action, key.Name))
+		}
+	}
+}
+
+// applyRestore adds the planned keys all or nothing: keys are checked first,
+// then secrets are written to the keyring and finally all records are stored
+// in a single bolt transaction. On any error, or on a signal from interrupt,
+// the keyring items written so far are put back as they were.
+func applyRestore(storage Storage, plan []plannedKey, interrupt <-chan os.Signal) (restoreResult, error) {
+	result := restoreResult{}
+
+	ring, err := openKeyring()
+	if err != nil {
+		return result, fmt.Errorf("cannot open keyring: %w", err)
+	}
+
+	type stagedKey struct {
+		key    Key
+		secret string
+	}
+	staged := make([]stagedKey, 0, len(plan))
+	for _, planned := range plan {
+		if planned.Action == restoreSkip {
+			result.Skipped = append(result.Skipped, skippedKey{Name: planned.Name, Reason: planned.Reason})
+			continue
+		}
+		key, err := buildKey(ring, planned.Target, planned.Secret, planned.Options)
+		if err != nil {
+			result.Failed = append(result.Failed, failedKey{Name: planned.Target, Err: err})
+			return result, fmt.Errorf("cannot add %s: %w", planned.Target, err)
+		}
+		staged = append(staged, stagedKey{key: key, secret: planned.Secret})
+	}
+
+	journal := keyringJournal{ring: ring}
+	fail := func(name string, err error) (restoreResult, error) {
+		if name != "" {
+			result.Failed = append(result.Failed, failedKey{Name: name, Err: err})
+		}
+		if rollbackErr := journal.rollback(); rollbackErr != nil {
+			return result, fmt.Errorf("%w, rollback failed: %s", err, rollbackErr)
+		}
+		return result, fmt.Errorf("%w, no key has been changed", err)
+	}
+
+	records := make(map[string][]byte, len(staged))
+	for _, s := range staged {
+		select {
+		case sig := <-interrupt:
+			return fail(s.key.Name, fmt.Errorf("interrupted by %s", sig))
+		default:
+		}
+
+		if err := journal.set(s.key, s.secret); err != nil {
+			return fail(s.key.Name, fmt.Errorf("cannot set secret for %s: %w", s.key.Name, err))
+		}
+		records[s.key.Name], err = json.Marshal(s.key)
+		if err != nil {
+			return fail(s.key.Name, err)
+		}
+	}
+
+	if err := storage.AddKeys(records); err != nil {
+		return fail("", fmt.Errorf("cannot store keys: %w", err))
+	}
+
+	for _, s := range staged {
+		result.Imported = append(result.Imported, s.key.Name)
+	}
+	return result, nil
+}
+
+// keyringJournal sets keyring items remembering the ones they replace, so
+// that they can be put back.
+type keyringJournal struct {
+	ring    keyring.Keyring
+	entries []journalEntry
+}
+
+type journalEntry struct {
+	name string
+	// previous item, nil when there was none
+	previous *keyring.Item
+}
+
+func (j *keyringJournal) set(key Key, secret string) error {
+	entry := journalEntry{name: key.Name}
+	previous, err := j.ring.Get(key.Name)
+	switch {
+	case err == nil:
+		entry.previous = &previous
+	case errors.Is(err, keyring.ErrKeyNotFound):
+	default:
+		return fmt.Errorf("cannot read current secret: %w", err)
+	}
+
+	// recorded before writing, a failed write may still have changed the item
+	j.entries = append(j.entries, entry)
+	return key.Secret(secret)
+}
+
+// rollback puts back the replaced items and removes the new ones, in reverse
+// order so that a key set twice ends up as it was at first.
+func (j *keyringJournal) rollback() error {
+	var failed []string
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		entry := j.entries[i]
+		var err error
+		if entry.previous != nil {
+			err = j.ring.Set(*entry.previous)
+		} else {
+			err = j.ring.Remove(entry.name)
+			if errors.Is(err, keyring.ErrKeyNotFound) {
+				err = nil
+			}
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", entry.name, err))
+		}
+	}
+	j.entries = nil
+
+	if len(failed) > 0 {
+		return fmt.Errorf("cannot restore keyring items %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// printRestoreResult prints a table of what happened to each key, followed by
+// the totals.
+func printRestoreResult(ui cli.Ui, result restoreResult) {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	for _, name := range result.Imported {
+		fmt.Fprintf(w, "imported\t%s\n", name)
+	}
+	for _, key := range result.Skipped {
+		fmt.Fprintf(w, "skipped\t%s\t%s\n", key.Name, key.Reason)
+	}
+	for _, key := range result.Failed {
+		fmt.Fprintf(w, "failed\t%s\t%s\n", key.Name, key.Err)
+	}
+	_ = w.Flush()
+
+	if table.Len() > 0 {
+		ui.Output(strings.TrimRight(table.String(), "\n"))
+	}
+	ui.Output(fmt.Sprintf("%d imported, %d skipped, %d failed",
+		len(result.Imported), len(result.Skipped), len(result.Failed)))
+}
@@ -1,7 +1,25 @@
 package main
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/endorama/2ami/internal/aegis"
 )
 
 func TestRestore_InvalidData(t *testing.T) {
@@ -9,7 +27,7 @@ func TestRestore_InvalidData(t *testing.T) {
 	storage := NewStorage("/tmp", "test.db")
 
 	// Test with invalid backup data
-	err := restore(storage, "invalid_backup_data", "password", backupFormat2ami)
+	_, err := restore(cli.NewMockUi(), storage, "invalid_backup_data", "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
 	if err == nil {
 		t.Error("Expected error for invalid backup data, got nil")
 	}
@@ -20,8 +38,661 @@ func TestRestore_EmptyData(t *testing.T) {
 	storage := NewStorage("/tmp", "test.db")
 
 	// Test with empty backup data
-	err := restore(storage, "", "password", backupFormat2ami)
+	_, err := restore(cli.NewMockUi(), storage, "", "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
 	if err == nil {
 		t.Error("Expected error for empty backup data, got nil")
 	}
 }
+
+// encryptLegacyBackup produces a v1 backup section, as written by 2ami before
+// the v2 format was introduced.
+func encryptLegacyBackup(t *testing.T, b backup, password string) string {
+	t.Helper()
+
+	b.Secret = base32.StdEncoding.EncodeToString([]byte(b.Secret))
+	encoded, err := json.Marshal(b)
+	require.NoError(t, err)
+
+	c, err := aes.NewCipher(deriveKey(password))
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(c)
+	require.NoError(t, err)
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	require.NoError(t, err)
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, encoded, nil))
+}
+
+func TestBackupFile_RoundTrip(t *testing.T) {
+	entries := []backup{
+		{Name: "one", Digits: "6", Interval: "30", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "two", Digits: "8", Interval: "60", Algorithm: "SHA256", Secret: "GEZDGNBVGY3TQOJQ"},
+	}
+
+	encrypted, err := encryptBackupFile(entries, "password")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "JBSWY3DPEHPK3PXP")
+
+	file := backupFile{}
+	require.NoError(t, json.Unmarshal([]byte(encrypted), &file))
+	assert.Equal(t, backupMagic, file.Magic)
+	assert.Equal(t, backupVersion, file.Version)
+	assert.Equal(t, backupKDFScrypt, file.KDF.Name)
+	assert.Len(t, file.KDF.Salt, backupSaltSize)
+
+	decrypted, err := decryptBackupFile(encrypted, "password")
+	require.NoError(t, err)
+	assert.Equal(t, entries, decrypted)
+}
+
+func TestBackupFile_RandomSalt(t *testing.T) {
+	entries := []backup{{Name: "one", Secret: "JBSWY3DPEHPK3PXP"}}
+
+	first, err := encryptBackupFile(entries, "password")
+	require.NoError(t, err)
+	second, err := encryptBackupFile(entries, "password")
+	require.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+}
+
+func TestBackupFile_WrongPassword(t *testing.T) {
+	encrypted, err := encryptBackupFile([]backup{{Name: "one", Secret: "JBSWY3DPEHPK3PXP"}}, "password")
+	require.NoError(t, err)
+
+	_, err = decryptBackupFile(encrypted, "wrong")
+	assert.Error(t, err)
+}
+
+func TestBackupFile_TamperedHeader(t *testing.T) {
+	encrypted, err := encryptBackupFile([]backup{{Name: "one", Secret: "JBSWY3DPEHPK3PXP"}}, "password")
+	require.NoError(t, err)
+
+	file := backupFile{}
+	require.NoError(t, json.Unmarshal([]byte(encrypted), &file))
+	file.Cipher = "AES-256-GCM"
+	tampered, err := json.Marshal(file)
+	require.NoError(t, err)
+
+	_, err = decryptBackupFile(string(tampered), "password")
+	assert.Error(t, err)
+
+	require.NoError(t, json.Unmarshal([]byte(encrypted), &file))
+	file.Version = 3
+	tampered, err = json.Marshal(file)
+	require.NoError(t, err)
+
+	_, err = decryptBackupFile(string(tampered), "password")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported backup version")
+}
+
+func TestBackupFile_ScryptLimits(t *testing.T) {
+	encrypted, err := encryptBackupFile([]backup{{Name: "one", Secret: "JBSWY3DPEHPK3PXP"}}, "password")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		tamper func(kdf *backupKDF)
+		want   string
+	}{
+		{"huge N", func(kdf *backupKDF) { kdf.N = 1 << 30 }, "unsupported scrypt N"},
+		{"N not a power of two", func(kdf *backupKDF) { kdf.N = 30000 }, "unsupported scrypt N"},
+		{"huge r", func(kdf *backupKDF) { kdf.R = 1 << 20 }, "unsupported scrypt r"},
+		{"huge p", func(kdf *backupKDF) { kdf.P = 1 << 20 }, "unsupported scrypt p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := backupFile{}
+			require.NoError(t, json.Unmarshal([]byte(encrypted), &file))
+			tt.tamper(&file.KDF)
+			tampered, err := json.Marshal(file)
+			require.NoError(t, err)
+
+			_, err = decryptBackupFile(string(tampered), "password")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestBackupFile_LegacyV1(t *testing.T) {
+	entries := []backup{
+		{Name: "one", Digits: "6", Interval: "30", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "two", Digits: "8", Interval: "60", Secret: "GEZDGNBVGY3TQOJQ"},
+	}
+	sections := make([]string, 0, len(entries))
+	for _, b := range entries {
+		sections = append(sections, encryptLegacyBackup(t, b, "password"))
+	}
+
+	decrypted, err := decryptBackupFile(strings.Join(sections, "."), "password")
+	require.NoError(t, err)
+	assert.Equal(t, entries, decrypted)
+}
+
+func TestRestore2ami_LegacyV1(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+
+	input := encryptLegacyBackup(t, backup{Name: "legacy", Digits: "8", Interval: "60", Secret: "JBSWY3DPEHPK3PXP"}, "password")
+	result, err := restore(cli.NewMockUi(), storage, input, "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed)
+
+	key := KeyFromStorage(storage, ring, "legacy")
+	assert.Equal(t, 8, key.Digits)
+	assert.Equal(t, 60, key.Interval)
+	secret, err := key.secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(secret))
+}
+
+func TestBackupAllKeys_Restore(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+
+	require.NoError(t, add(storage, "one", "JBSWY3DPEHPK3PXP", keyOptions{Digits: "8"}))
+
+	encrypted, err := backupAllKeys(storage, "password")
+	require.NoError(t, err)
+
+	require.NoError(t, storage.RemoveKey("one"))
+	result, err := restore(cli.NewMockUi(), storage, encrypted, "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed)
+
+	key := KeyFromStorage(storage, ring, "one")
+	assert.Equal(t, 8, key.Digits)
+	secret, err := key.secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(secret))
+}
+
+// captureStdout returns what fn prints on the standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	original := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = original }()
+
+	fn()
+
+	require.NoError(t, w.Close())
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestBackupAllKeys_RoundTripMetadata(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	useTestKeyring(t)
+
+	require.NoError(t, add(storage, "totp", "JBSWY3DPEHPK3PXP", keyOptions{
+		Digits: "8", Interval: "90", Algorithm: "SHA512", T0: "100",
+		Issuer: "Example", Account: "alice@example.com",
+		Tags: []string{"work", "vpn"}, Note: "recovery codes in the safe", Favorite: true,
+	}))
+	require.NoError(t, add(storage, "hotp", "GEZDGNBVGY3TQOJQ", keyOptions{Type: "hotp", Counter: "42"}))
+	require.NoError(t, add(storage, "steam", "JBSWY3DPEHPK3PXP", keyOptions{Type: "steam"}))
+
+	dump := func() string {
+		return captureStdout(t, func() {
+			require.Empty(t, dumpAllKeys(storage))
+		})
+	}
+	before := dump()
+
+	encrypted, err := backupAllKeys(storage, "password")
+	require.NoError(t, err)
+
+	for _, name := range []string{"totp", "hotp", "steam"} {
+		require.NoError(t, storage.RemoveKey(name))
+	}
+	result, err := restore(cli.NewMockUi(), storage, encrypted, "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
+	require.NoError(t, err)
+	assert.Empty(t, result.Failed)
+
+	assert.Equal(t, before, dump())
+	assert.Contains(t, before, `"type":"hotp"`)
+	assert.Contains(t, before, `"counter":42`)
+}
+
+// setupConflictingRestore stores "one" with 6 digits and returns a backup
+// holding "one" with 8 digits and "two".
+func setupConflictingRestore(t *testing.T) (Storage, string) {
+	t.Helper()
+
+	storage, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+	useTestKeyring(t)
+
+	require.NoError(t, add(storage, "one", "JBSWY3DPEHPK3PXP", keyOptions{}))
+
+	input, err := encryptBackupFile([]backup{
+		{Name: "one", Digits: "8", Interval: "30", Secret: "GEZDGNBVGY3TQOJQ"},
+		{Name: "two", Digits: "6", Interval: "30", Secret: "GEZDGNBVGY3TQOJQ"},
+	}, "password")
+	require.NoError(t, err)
+
+	return storage, input
+}
+
+func TestRestore_RenameAvoidsBackupNames(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	ring := useTestKeyring(t)
+	require.NoError(t, add(storage, "a", "JBSWY3DPEHPK3PXP", keyOptions{}))
+
+	input, err := encryptBackupFile([]backup{
+		{Name: "a", Digits: "6", Interval: "30", Secret: "GEZDGNBVGY3TQOJQ"},
+		{Name: "a (2)", Digits: "6", Interval: "30", Secret: "MZXW6YTBOI"},
+	}, "password")
+	require.NoError(t, err)
+
+	result, err := restore(cli.NewMockUi(), storage, input, "password", backupFormat2ami, restoreOptions{OnConflict: conflictRename})
+	require.NoError(t, err)
+	assert.Empty(t, result.Skipped)
+
+	names, err := storage.ListKey()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "a (2)", "a (3)"}, names)
+	for name, want := range map[string]string{"a": "JBSWY3DPEHPK3PXP", "a (2)": "MZXW6YTBOI", "a (3)": "GEZDGNBVGY3TQOJQ"} {
+		item, err := ring.Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(item.Data), name)
+	}
+}
+
+func TestRestore_DryRun(t *testing.T) {
+	tests := []struct {
+		policy conflictPolicy
+		want   string
+	}{
+		{conflictSkip, "skip      one (already exists)\nadd       two\n"},
+		{conflictOverwrite, "overwrite one\nadd       two\n"},
+		{conflictRename, "rename    one -> one (2)\nadd       two\n"},
+		{conflictAsk, "ask       one (already exists)\nadd       two\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			storage, input := setupConflictingRestore(t)
+			ui := cli.NewMockUi()
+
+			_, err := restore(ui, storage, input, "password", backupFormat2ami, restoreOptions{DryRun: true, OnConflict: tt.policy})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ui.OutputWriter.String())
+
+			names, err := storage.ListKey()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"one"}, names)
+		})
+	}
+}
+
+func TestRestore_OnConflict(t *testing.T) {
+	tests := []struct {
+		policy    conflictPolicy
+		answer    string
+		wantNames []string
+		// wantDigits of the key named "one"
+		wantDigits int
+	}{
+		{policy: conflictSkip, wantNames: []string{"one", "two"}, wantDigits: 6},
+		{policy: conflictOverwrite, wantNames: []string{"one", "two"}, wantDigits: 8},
+		{policy: conflictRename, wantNames: []string{"one", "one (2)", "two"}, wantDigits: 6},
+		{policy: conflictAsk, answer: "maybe\noverwrite\n", wantNames: []string{"one", "two"}, wantDigits: 8},
+		{policy: conflictAsk, answer: "rename\n", wantNames: []string{"one", "one (2)", "two"}, wantDigits: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			storage, input := setupConflictingRestore(t)
+			ui := cli.NewMockUi()
+			// MockUi buffers its input on every Ask, read a byte at a time
+			ui.InputReader = iotest.OneByteReader(strings.NewReader(tt.answer))
+
+			_, err := restore(ui, storage, input, "password", backupFormat2ami, restoreOptions{OnConflict: tt.policy})
+			require.NoError(t, err)
+
+			names, err := storage.ListKey()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNames, names)
+
+			ring, err := openKeyring()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDigits, KeyFromStorage(storage, ring, "one").Digits)
+		})
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := parseConflictPolicy("Rename")
+	require.NoError(t, err)
+	assert.Equal(t, conflictRename, policy)
+
+	_, err = parseConflictPolicy("merge")
+	assert.Error(t, err)
+}
+
+func TestRestore_Result(t *testing.T) {
+	storage, input := setupConflictingRestore(t)
+
+	result, err := restore(cli.NewMockUi(), storage, input, "password", backupFormat2ami, restoreOptions{OnConflict: conflictSkip})
+	require.NoError(t, err)
+
+	assert.Equal(t, restoreResult{
+		Imported: []string{"two"},
+		Skipped:  []skippedKey{{Name: "one", Reason: "already exists"}},
+	}, result)
+}
+
+func TestAegisKeys_Skipped(t *testing.T) {
+	entries := []aegis.Entry{
+		{Type: "totp", Name: "valid", Issuer: "Example", Info: map[string]interface{}{"secret": "JBSWY3DPEHPK3PXP"}},
+		{Type: "totp", Name: "missing", Info: map[string]interface{}{}},
+		{Type: "totp", Name: "number", Info: map[string]interface{}{"secret": 42.0}},
+		{Type: "totp", Name: "invalid", Issuer: "Example", Info: map[string]interface{}{"secret": "not base32!"}},
+	}
+
+	keys, skipped := aegisKeys(entries, nil)
+
+	require.Len(t, keys, 1)
+	assert.Equal(t, "Example - valid", keys[0].Name)
+	require.Len(t, skipped, 3)
+	assert.Equal(t, skippedKey{Name: "missing", Reason: "no secret found"}, skipped[0])
+	assert.Equal(t, skippedKey{Name: "number", Reason: "invalid secret type"}, skipped[1])
+	assert.Equal(t, "Example - invalid", skipped[2].Name)
+	assert.Contains(t, skipped[2].Reason, "invalid base32 secret")
+}
+
+func TestPrintRestoreResult(t *testing.T) {
+	ui := cli.NewMockUi()
+
+	printRestoreResult(ui, restoreResult{
+		Imported: []string{"one"},
+		Skipped:  []skippedKey{{Name: "two", Reason: "already exists"}},
+		Failed:   []failedKey{{Name: "three", Err: errors.New("keyring locked")}},
+	})
+
+	assert.Equal(t, `imported  one
+skipped   two    already exists
+failed    three  keyring locked
+1 imported, 1 skipped, 1 failed
+`, ui.OutputWriter.String())
+}
+
+// hookedKeyring calls beforeSet before setting any item, failing the Set if
+// it returns an error.
+type hookedKeyring struct {
+	keyring.Keyring
+	beforeSet func(item keyring.Item) error
+}
+
+func (r hookedKeyring) Set(item keyring.Item) error {
+	if err := r.beforeSet(item); err != nil {
+		return err
+	}
+	return r.Keyring.Set(item)
+}
+
+// assertVaultUnchanged checks the vault is as set up by setupConflictingRestore.
+func assertVaultUnchanged(t *testing.T, storage Storage, ring keyring.Keyring) {
+	t.Helper()
+
+	names, err := storage.ListKey()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one"}, names)
+
+	key := KeyFromStorage(storage, ring, "one")
+	assert.Equal(t, 6, key.Digits)
+	secret, err := key.secret.Value()
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(secret))
+
+	_, err = ring.Get("two")
+	assert.ErrorIs(t, err, keyring.ErrKeyNotFound)
+}
+
+func TestRestore_RollbackOnKeyringError(t *testing.T) {
+	storage, input := setupConflictingRestore(t)
+	ring, err := openKeyring()
+	require.NoError(t, err)
+
+	hooked := hookedKeyring{Keyring: ring, beforeSet: func(item keyring.Item) error {
+		if item.Key == "two" {
+			return errors.New("keyring locked")
+		}
+		return nil
+	}}
+	openKeyring = func() (keyring.Keyring, error) { return hooked, nil }
+
+	result, err := restore(cli.NewMockUi(), storage, input, "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "keyring locked")
+	assert.Empty(t, result.Imported)
+	require.Len(t, result.Failed, 1)
+	assert.Equal(t, "two", result.Failed[0].Name)
+
+	assertVaultUnchanged(t, storage, ring)
+}
+
+func TestRestore_RollbackOnInvalidKey(t *testing.T) {
+	storage, _ := setupConflictingRestore(t)
+	ring, err := openKeyring()
+	require.NoError(t, err)
+
+	input, err := encryptBackupFile([]backup{
+		{Name: "one", Digits: "8", Interval: "30", Secret: "GEZDGNBVGY3TQOJQ"},
+		{Name: "two", Digits: "eight", Interval: "30", Secret: "GEZDGNBVGY3TQOJQ"},
+	}, "password")
+	require.NoError(t, err)
+
+	_, err = restore(cli.NewMockUi(), storage, input, "password", backupFormat2ami, restoreOptions{OnConflict: conflictOverwrite})
+	require.Error(t, err)
+
+	assertVaultUnchanged(t, storage, ring)
+}
+
+func TestApplyRestore_Interrupted(t *testing.T) {
+	storage, input := setupConflictingRestore(t)
+	ring, err := openKeyring()
+	require.NoError(t, err)
+
+	keys, _, err := readBackup(input, "password", backupFormat2ami)
+	require.NoError(t, err)
+	plan, err := planRestore(cli.NewMockUi(), storage, keys, restoreOptions{OnConflict: conflictOverwrite})
+	require.NoError(t, err)
+
+	// interrupt once the first secret has been written
+	interrupt := make(chan os.Signal, 1)
+	openKeyring = func() (keyring.Keyring, error) {
+		return hookedKeyring{Keyring: ring, beforeSet: func(item keyring.Item) error {
+			select {
+			case interrupt <- os.Interrupt:
+			default:
+			}
+			return nil
+		}}, nil
+	}
+
+	_, err = applyRestore(storage, plan, interrupt)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "interrupted")
+
+	assertVaultUnchanged(t, storage, ring)
+}
+
+func TestListBackup_Filters(t *testing.T) {
+	input, err := os.ReadFile("internal/aegis/testdata/aegis_plain.json")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		options restoreOptions
+		want    []string
+	}{
+		{
+			name: "all",
+			want: []string{
+				"Deno - Mason", "SPDX - James", "Airbnb - Elijah", "Issuu - James",
+				"Air Canada - Benjamin", "WWE - Mason", "Boeing - Sophia",
+			},
+		},
+		{
+			name:    "only",
+			options: restoreOptions{Only: []string{"WWE - Mason", "Deno - Mason"}},
+			want:    []string{"Deno - Mason", "WWE - Mason"},
+		},
+		{
+			name:    "match",
+			options: restoreOptions{Match: "Air*"},
+			want:    []string{"Airbnb - Elijah", "Air Canada - Benjamin"},
+		},
+		{
+			name:    "only and match",
+			options: restoreOptions{Only: []string{"Boeing - Sophia"}, Match: "* - James"},
+			want:    []string{"SPDX - James", "Issuu - James", "Boeing - Sophia"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := listBackup(string(input), "", backupFormatAegis, tt.options)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func TestListBackup_OnlyMissing(t *testing.T) {
+	input, err := encryptBackupFile([]backup{{Name: "one", Secret: "JBSWY3DPEHPK3PXP"}}, "password")
+	require.NoError(t, err)
+
+	_, err = listBackup(input, "password", backupFormat2ami, restoreOptions{Only: []string{"one", "two"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "two")
+
+	_, err = listBackup(input, "password", backupFormat2ami, restoreOptions{Match: "[one"})
+	assert.Error(t, err)
+}
+
+func TestRestore_Only(t *testing.T) {
+	storage, input := setupConflictingRestore(t)
+
+	result, err := restore(cli.NewMockUi(), storage, input, "password", backupFormat2ami, restoreOptions{
+		OnConflict: conflictOverwrite,
+		Only:       []string{"two"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"two"}, result.Imported)
+
+	ring, err := openKeyring()
+	require.NoError(t, err)
+	assert.Equal(t, 6, KeyFromStorage(storage, ring, "one").Digits)
+}
+
+func TestBackupAllKeysAegis_RoundTrip(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	useTestKeyring(t)
+
+	require.NoError(t, add(storage, "Example - alice", "jbswy3dpehpk3pxp", keyOptions{
+		Digits: "8", Interval: "60", Algorithm: "SHA256", Issuer: "Example", Account: "alice",
+		Tags: []string{"work"}, Note: "shared account", Favorite: true,
+	}))
+	require.NoError(t, add(storage, "hotp", "GEZDGNBVGY3TQOJQ", keyOptions{Type: "hotp", Counter: "42", Tags: []string{"work"}}))
+	require.NoError(t, add(storage, "steam", "JBSWY3DPEHPK3PXP", keyOptions{Type: "steam"}))
+
+	encrypted, err := backupAllKeysAegis(storage, "password")
+	require.NoError(t, err)
+
+	vault, err := aegis.ParseBackup([]byte(encrypted))
+	require.NoError(t, err)
+	db, err := vault.DecryptBackup("password")
+	require.NoError(t, err)
+	require.Len(t, db.Entries, 3)
+
+	// keys are listed by name
+	totp, hotp, steam := db.Entries[0], db.Entries[1], db.Entries[2]
+	assert.Equal(t, "totp", totp.Type)
+	assert.Equal(t, "alice", totp.Name)
+	assert.Equal(t, "Example", totp.Issuer)
+	assert.Equal(t, map[string]interface{}{
+		"secret": "JBSWY3DPEHPK3PXP", "algo": "SHA256", "digits": 8.0, "period": 60.0,
+	}, totp.Info)
+	assert.Equal(t, "hotp", hotp.Type)
+	assert.Equal(t, map[string]interface{}{
+		"secret": "GEZDGNBVGY3TQOJQ", "algo": "SHA1", "digits": 6.0, "counter": 42.0,
+	}, hotp.Info)
+	assert.Equal(t, "steam", steam.Type)
+	assert.Equal(t, 5.0, steam.Info["digits"])
+
+	require.Len(t, db.Groups, 1)
+	assert.Equal(t, "work", db.Groups[0].Name)
+	assert.Equal(t, []string{db.Groups[0].UUID}, totp.Groups)
+	assert.Equal(t, []string{db.Groups[0].UUID}, hotp.Groups)
+	assert.Empty(t, steam.Groups)
+	assert.Equal(t, "shared account", totp.Note)
+	assert.True(t, totp.Favorite)
+
+	keys, skipped, err := readBackup(encrypted, "password", backupFormatAegis)
+	require.NoError(t, err)
+	assert.Empty(t, skipped)
+	require.Len(t, keys, 3)
+	assert.Equal(t, "Example - alice", keys[0].Name)
+	assert.Equal(t, "Example", keys[0].Options.Issuer)
+	assert.Equal(t, "alice", keys[0].Options.Account)
+	assert.Equal(t, []string{"work"}, keys[0].Options.Tags)
+	assert.Equal(t, "shared account", keys[0].Options.Note)
+	assert.Equal(t, true, keys[0].Options.Favorite)
+	assert.Equal(t, HOTP_TOKEN, keys[1].Options.Type)
+	assert.Equal(t, 42, keys[1].Options.Counter)
+	assert.Equal(t, STEAM_TOKEN, keys[2].Options.Type)
+}
+
+func TestAegisKeys_Metadata(t *testing.T) {
+	groups := []aegis.Group{
+		{UUID: "a3f3b1e0-2c1d-4b7e-9a55-3c1f0e5d7a01", Name: "Work"},
+		{UUID: "b7d2c4a1-5e6f-4a8b-8c9d-0e1f2a3b4c02", Name: "Personal"},
+	}
+	entries := []aegis.Entry{
+		{
+			Type: "totp", Name: "alice@example.com", Issuer: "Example",
+			Note: "backup codes in the safe", Favorite: true,
+			Groups: []string{groups[1].UUID, "00000000-0000-4000-8000-000000000000", groups[0].UUID},
+			Info:   map[string]interface{}{"secret": "JBSWY3DPEHPK3PXP"},
+		},
+		{Type: "totp", Name: "bob", Info: map[string]interface{}{"secret": "JBSWY3DPEHPK3PXP"}},
+	}
+
+	keys, skipped := aegisKeys(entries, groups)
+	require.Empty(t, skipped)
+	require.Len(t, keys, 2)
+
+	assert.Equal(t, importedKey{
+		Name:   "Example - alice@example.com",
+		Secret: "JBSWY3DPEHPK3PXP",
+		Options: keyOptions{
+			Issuer:   "Example",
+			Account:  "alice@example.com",
+			Tags:     []string{"Personal", "Work"},
+			Note:     "backup codes in the safe",
+			Favorite: true,
+		},
+	}, keys[0])
+	assert.Equal(t, importedKey{
+		Name:    "bob",
+		Secret:  "JBSWY3DPEHPK3PXP",
+		Options: keyOptions{Account: "bob"},
+	}, keys[1])
+}
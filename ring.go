@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+)
+
+// migrateRing copies the secret of every key from source to target, checking
+// each copy by reading it back. Source items are removed only once all keys
+// have been copied; on error target is put back as it was.
+func migrateRing(ui cli.Ui, storage Storage, source keyring.Keyring, target keyring.Keyring) error {
+	// copying a keyring onto itself would end with every secret removed
+	if err := checkDistinctKeyrings(source, target); err != nil {
+		return err
+	}
+
+	names, err := storage.ListKey()
+	if err != nil {
+		return err
+	}
+
+	journal := keyringJournal{ring: target}
+	fail := func(err error) error {
+		if rollbackErr := journal.rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w, rollback failed: %s", err, rollbackErr)
+		}
+		return fmt.Errorf("%w, no secret has been moved", err)
+	}
+
+	for _, name := range names {
+		original := newSecretString(name, source)
+		secret, err := original.Value()
+		if err != nil {
+			return fail(fmt.Errorf("cannot read %s: %w", name, err))
+		}
+
+		key := Key{Name: name, secret: newSecretString(name, target)}
+		if err := journal.set(key, string(secret)); err != nil {
+			return fail(fmt.Errorf("cannot copy %s: %w", name, err))
+		}
+
+		copied, err := key.secret.Value()
+		if err != nil {
+			return fail(fmt.Errorf("cannot read back %s: %w", name, err))
+		}
+		if !bytes.Equal(copied, secret) {
+			return fail(fmt.Errorf("copy of %s differs from the original", name))
+		}
+		debugPrint(fmt.Sprintf("Copied secret of %s", name))
+	}
+
+	for _, name := range names {
+		secret := newSecretString(name, source)
+		if err := secret.Remove(); err != nil {
+			ui.Warn(fmt.Sprintf("cannot remove %s from the source keyring: %s", name, err))
+		}
+	}
+
+	ui.Info(fmt.Sprintf("Moved %d secrets", len(names)))
+	return nil
+}
+
+// checkDistinctKeyrings writes a probe item to target and looks for it in
+// source, to tell whether they are the same store whatever names they were
+// opened with.
+func checkDistinctKeyrings(source keyring.Keyring, target keyring.Keyring) error {
+	probe := probeItem()
+	if err := target.Set(probe); err != nil {
+		return fmt.Errorf("cannot write to target keyring: %w", err)
+	}
+	_, err := source.Get(probe.Key)
+	removeErr := target.Remove(probe.Key)
+
+	switch {
+	case err == nil:
+		return errors.New("target keyring is the current one, aborting")
+	case !errors.Is(err, keyring.ErrKeyNotFound):
+		return fmt.Errorf("cannot read source keyring: %w", err)
+	case removeErr != nil:
+		return fmt.Errorf("cannot remove probe item from target keyring: %w", removeErr)
+	}
+	return nil
+}
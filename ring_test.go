@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRingMigration stores keys "one" and "two" in a source keyring and
+// returns it with an empty target keyring.
+func setupRingMigration(t *testing.T) (Storage, keyring.Keyring, keyring.Keyring) {
+	t.Helper()
+
+	storage, cleanup := setupTestStorage(t)
+	t.Cleanup(cleanup)
+	source := useTestKeyring(t)
+	target, err := openTestKeyring(t)
+	require.NoError(t, err)
+
+	require.NoError(t, add(storage, "one", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	require.NoError(t, add(storage, "two", "GEZDGNBVGY3TQOJQ", keyOptions{}))
+
+	return storage, source, target
+}
+
+func TestMigrateRing(t *testing.T) {
+	storage, source, target := setupRingMigration(t)
+	ui := cli.NewMockUi()
+
+	require.NoError(t, migrateRing(ui, storage, source, target))
+
+	for name, want := range map[string]string{"one": "JBSWY3DPEHPK3PXP", "two": "GEZDGNBVGY3TQOJQ"} {
+		item, err := target.Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(item.Data))
+
+		_, err = source.Get(name)
+		assert.ErrorIs(t, err, keyring.ErrKeyNotFound)
+	}
+	assert.Contains(t, ui.OutputWriter.String(), "Moved 2 secrets")
+}
+
+func TestMigrateRing_Rollback(t *testing.T) {
+	storage, source, target := setupRingMigration(t)
+	require.NoError(t, target.Set(keyring.Item{Key: "one", Data: []byte("MZXW6YTBOI")}))
+
+	failing := hookedKeyring{Keyring: target, beforeSet: func(item keyring.Item) error {
+		if item.Key == "two" {
+			return errors.New("keyring locked")
+		}
+		return nil
+	}}
+
+	err := migrateRing(cli.NewMockUi(), storage, source, failing)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "keyring locked")
+
+	// the target item replaced by the copy is back
+	item, err := target.Get("one")
+	require.NoError(t, err)
+	assert.Equal(t, "MZXW6YTBOI", string(item.Data))
+	_, err = target.Get("two")
+	assert.ErrorIs(t, err, keyring.ErrKeyNotFound)
+
+	// the source is untouched
+	for _, name := range []string{"one", "two"} {
+		_, err := source.Get(name)
+		assert.NoError(t, err)
+	}
+}
+
+func TestMigrateRing_MissingSecret(t *testing.T) {
+	storage, source, target := setupRingMigration(t)
+	require.NoError(t, source.Remove("two"))
+
+	err := migrateRing(cli.NewMockUi(), storage, source, target)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot read two")
+
+	_, err = target.Get("one")
+	assert.ErrorIs(t, err, keyring.ErrKeyNotFound)
+	_, err = source.Get("one")
+	assert.NoError(t, err)
+}
+
+func TestMigrateRing_SameKeyring(t *testing.T) {
+	storage, source, _ := setupRingMigration(t)
+
+	err := migrateRing(cli.NewMockUi(), storage, source, source)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "target keyring is the current one")
+
+	for name, want := range map[string]string{"one": "JBSWY3DPEHPK3PXP", "two": "GEZDGNBVGY3TQOJQ"} {
+		item, err := source.Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(item.Data))
+	}
+	keys, err := source.Keys()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"one", "two"}, keys)
+}
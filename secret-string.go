@@ -30,7 +30,7 @@ func (s *SecretString) Set(data []byte) error {
 		Key:         s.Name,
 		Label:       s.Name,
 		Data:        data,
-		Description: fmt.Sprintf("2FA key for %s", s.Name),
+		Description: secretDescription(s.Name),
 	}
 	err := s.ring.Set(item)
 	if err != nil {
@@ -39,6 +39,12 @@ func (s *SecretString) Set(data []byte) error {
 	return nil
 }
 
+// secretDescription returns the description of the keyring item holding the
+// secret of the key named name, which tells 2ami items from others.
+func secretDescription(name string) string {
+	return fmt.Sprintf("2FA key for %s", name)
+}
+
 // Value returns value of the current string, if present
 // Can fail if reading from secure storage fails
 func (s *SecretString) Value() ([]byte, error) {
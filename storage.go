@@ -72,6 +72,71 @@ func (s *Storage) AddKey(key string, value []byte) (bool, error) {
 	return true, nil
 }
 
+// AddKeys puts all values in a single transaction, either all of them are
+// stored or none is.
+func (s *Storage) AddKeys(values map[string][]byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbBucket))
+
+		for key, value := range values {
+			if err := bucket.Put([]byte(key), value); err != nil {
+				return fmt.Errorf("cannot put %s: %w", key, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// UpdateKey replaces the value of key with the one returned by fn, reading
+// and writing it in a single transaction. As bolt holds an exclusive file lock
+// while the database is open, no other process can interleave with it.
+func (s *Storage) UpdateKey(key string, fn func(value []byte) ([]byte, error)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbBucket))
+		if bucket == nil {
+			return errors.New(fmt.Sprintf("bucket %s not found", dbBucket))
+		}
+
+		value := bucket.Get([]byte(key))
+		if value == nil {
+			return errors.New(fmt.Sprintf("key %s not found", key))
+		}
+
+		updated, err := fn(value)
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put([]byte(key), updated)
+		if err != nil {
+			return fmt.Errorf("cannot put: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// MoveKey stores value as to and deletes from in a single transaction, either
+// both happen or none does.
+func (s *Storage) MoveKey(from string, to string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(dbBucket))
+		if bucket == nil {
+			return errors.New(fmt.Sprintf("bucket %s not found", dbBucket))
+		}
+
+		if err := bucket.Put([]byte(to), value); err != nil {
+			return fmt.Errorf("cannot put %s: %w", to, err)
+		}
+		if err := bucket.Delete([]byte(from)); err != nil {
+			return fmt.Errorf("cannot delete %s: %w", from, err)
+		}
+
+		return nil
+	})
+}
+
 func (s *Storage) ListKey() ([]string, error) {
 	var keys []string
 	err := s.db.View(func(tx *bolt.Tx) error {
@@ -389,3 +389,31 @@ func TestStorage_LargeValues(t *testing.T) {
 		t.Errorf("Large value was not stored/retrieved correctly")
 	}
 }
+
+func TestStorage_MoveKey(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+
+	if _, err := storage.AddKey("old", []byte("oldvalue")); err != nil {
+		t.Fatalf("AddKey() failed: %v", err)
+	}
+
+	if err := storage.MoveKey("old", "new", []byte("newvalue")); err != nil {
+		t.Fatalf("MoveKey() failed: %v", err)
+	}
+
+	keys, err := storage.ListKey()
+	if err != nil {
+		t.Fatalf("ListKey() failed: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"new"}) {
+		t.Errorf("Expected keys [new], got %v", keys)
+	}
+	value, err := storage.GetKey("new")
+	if err != nil {
+		t.Errorf("GetKey() failed: %v", err)
+	}
+	if !reflect.DeepEqual(value, []byte("newvalue")) {
+		t.Errorf("Expected value newvalue, got %s", value)
+	}
+}
@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/99designs/keyring"
+	"github.com/mitchellh/cli"
+)
+
+// backupReport is the result of checking a backup against the vault.
+type backupReport struct {
+	// Keys is the number of keys in the backup.
+	Keys int
+	// Missing keys are in the vault but not in the backup.
+	Missing []string
+	// Extra keys are in the backup but not in the vault.
+	Extra []string
+	// Differing keys are in both, with the fields that do not match.
+	Differing []differingKey
+	// Invalid keys cannot be restored.
+	Invalid []skippedKey
+}
+
+type differingKey struct {
+	Name   string
+	Fields []string
+}
+
+// OK reports whether the backup holds exactly the keys in the vault.
+func (r backupReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Differing) == 0 && len(r.Invalid) == 0
+}
+
+// verifyBackup decrypts a 2ami backup and compares its keys, secrets
+// included, with the ones in the vault.
+func verifyBackup(storage Storage, input string, password string) (backupReport, error) {
+	entries, err := decryptBackupFile(input, password)
+	if err != nil {
+		return backupReport{}, err
+	}
+
+	ring, err := openKeyring()
+	if err != nil {
+		return backupReport{}, fmt.Errorf("cannot open keyring: %w", err)
+	}
+	names, err := storage.ListKey()
+	if err != nil {
+		return backupReport{}, err
+	}
+	inVault := make(map[string]bool, len(names))
+	for _, name := range names {
+		inVault[name] = true
+	}
+
+	report := backupReport{Keys: len(entries)}
+	inBackup := make(map[string]bool, len(entries))
+	for _, b := range entries {
+		inBackup[b.Name] = true
+
+		backedUp, err := buildKey(ring, b.Name, b.Secret, b.options())
+		if err != nil {
+			report.Invalid = append(report.Invalid, skippedKey{Name: b.Name, Reason: err.Error()})
+			continue
+		}
+		if !inVault[b.Name] {
+			report.Extra = append(report.Extra, b.Name)
+			continue
+		}
+
+		fields, err := vaultKeyDifferences(storage, ring, backedUp, b.Secret)
+		if err != nil {
+			report.Invalid = append(report.Invalid, skippedKey{Name: b.Name, Reason: err.Error()})
+			continue
+		}
+		if len(fields) > 0 {
+			report.Differing = append(report.Differing, differingKey{Name: b.Name, Fields: fields})
+		}
+	}
+
+	for _, name := range names {
+		if !inBackup[name] {
+			report.Missing = append(report.Missing, name)
+		}
+	}
+
+	return report, nil
+}
+
+// vaultKeyDifferences returns the fields of the key in the vault that differ
+// from backedUp.
+func vaultKeyDifferences(storage Storage, ring keyring.Keyring, backedUp Key, secret string) ([]string, error) {
+	value, err := storage.GetKey(backedUp.Name)
+	if err != nil {
+		return nil, err
+	}
+	stored := Key{}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, fmt.Errorf("cannot parse vault key: %w", err)
+	}
+
+	fields := keyDifferences(stored, backedUp)
+
+	item, err := ring.Get(backedUp.Name)
+	if err != nil || string(item.Data) != secret {
+		fields = append(fields, "secret")
+	}
+	return fields, nil
+}
+
+// keyDifferences returns the names of the fields that differ between a and b,
+// secrets excluded.
+func keyDifferences(a Key, b Key) []string {
+	// keys stored before algorithms were configurable have none
+	algorithm := func(k Key) Algorithm {
+		if k.Algorithm == "" {
+			return SHA1_ALGORITHM
+		}
+		return k.Algorithm
+	}
+
+	var fields []string
+	if a.Type != b.Type {
+		fields = append(fields, "type")
+	}
+	if a.Digits != b.Digits {
+		fields = append(fields, "digits")
+	}
+	if a.Interval != b.Interval {
+		fields = append(fields, "interval")
+	}
+	if a.Counter != b.Counter {
+		fields = append(fields, "counter")
+	}
+	if algorithm(a) != algorithm(b) {
+		fields = append(fields, "algorithm")
+	}
+	if a.T0 != b.T0 {
+		fields = append(fields, "t0")
+	}
+	if a.Issuer != b.Issuer {
+		fields = append(fields, "issuer")
+	}
+	if a.Account != b.Account {
+		fields = append(fields, "account")
+	}
+	if strings.Join(a.Tags, ",") != strings.Join(b.Tags, ",") {
+		fields = append(fields, "tags")
+	}
+	if a.Note != b.Note {
+		fields = append(fields, "note")
+	}
+	if a.Favorite != b.Favorite {
+		fields = append(fields, "favorite")
+	}
+	return fields
+}
+
+func printBackupReport(ui cli.Ui, report backupReport) {
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	for _, name := range report.Missing {
+		fmt.Fprintf(w, "missing\t%s\tnot in the backup\n", name)
+	}
+	for _, name := range report.Extra {
+		fmt.Fprintf(w, "extra\t%s\tnot in the vault\n", name)
+	}
+	for _, key := range report.Differing {
+		fmt.Fprintf(w, "differs\t%s\t%s\n", key.Name, strings.Join(key.Fields, ", "))
+	}
+	for _, key := range report.Invalid {
+		fmt.Fprintf(w, "invalid\t%s\t%s\n", key.Name, key.Reason)
+	}
+	_ = w.Flush()
+
+	if table.Len() > 0 {
+		ui.Output(strings.TrimRight(table.String(), "\n"))
+	}
+	ui.Output(fmt.Sprintf("%d keys in backup: %d missing, %d extra, %d differing, %d invalid",
+		report.Keys, len(report.Missing), len(report.Extra), len(report.Differing), len(report.Invalid)))
+}
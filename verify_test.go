@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyBackup_Identical(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	useTestKeyring(t)
+
+	require.NoError(t, add(storage, "one", "JBSWY3DPEHPK3PXP", keyOptions{Issuer: "Example"}))
+	require.NoError(t, add(storage, "two", "GEZDGNBVGY3TQOJQ", keyOptions{Type: "hotp", Counter: "7"}))
+
+	input, err := backupAllKeys(storage, "password")
+	require.NoError(t, err)
+
+	report, err := verifyBackup(storage, input, "password")
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 2, report.Keys)
+}
+
+func TestVerifyBackup_Report(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	useTestKeyring(t)
+
+	require.NoError(t, add(storage, "same", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	require.NoError(t, add(storage, "digits", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	require.NoError(t, add(storage, "secret", "JBSWY3DPEHPK3PXP", keyOptions{}))
+	require.NoError(t, add(storage, "missing", "JBSWY3DPEHPK3PXP", keyOptions{}))
+
+	input, err := encryptBackupFile([]backup{
+		{Name: "same", Type: "totp", Digits: "6", Interval: "30", Counter: "1", Algorithm: "SHA1", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "digits", Type: "totp", Digits: "8", Interval: "30", Counter: "1", Algorithm: "SHA1", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "secret", Type: "totp", Digits: "6", Interval: "30", Counter: "1", Algorithm: "SHA1", Secret: "GEZDGNBVGY3TQOJQ"},
+		{Name: "extra", Digits: "6", Interval: "30", Secret: "JBSWY3DPEHPK3PXP"},
+		{Name: "invalid", Digits: "6", Interval: "30", Secret: "not base32!"},
+	}, "password")
+	require.NoError(t, err)
+
+	report, err := verifyBackup(storage, input, "password")
+	require.NoError(t, err)
+
+	assert.False(t, report.OK())
+	assert.Equal(t, 5, report.Keys)
+	assert.Equal(t, []string{"missing"}, report.Missing)
+	assert.Equal(t, []string{"extra"}, report.Extra)
+	assert.Equal(t, []differingKey{
+		{Name: "digits", Fields: []string{"digits"}},
+		{Name: "secret", Fields: []string{"secret"}},
+	}, report.Differing)
+	require.Len(t, report.Invalid, 1)
+	assert.Equal(t, "invalid", report.Invalid[0].Name)
+
+	ui := cli.NewMockUi()
+	printBackupReport(ui, report)
+	assert.Contains(t, ui.OutputWriter.String(), "differs  digits   digits\n")
+	assert.Contains(t, ui.OutputWriter.String(), "5 keys in backup: 1 missing, 1 extra, 2 differing, 1 invalid\n")
+}
+
+func TestVerifyBackup_WrongPassword(t *testing.T) {
+	storage, cleanup := setupTestStorage(t)
+	defer cleanup()
+	useTestKeyring(t)
+
+	input, err := encryptBackupFile([]backup{{Name: "one", Secret: "JBSWY3DPEHPK3PXP"}}, "password")
+	require.NoError(t, err)
+
+	_, err = verifyBackup(storage, input, "wrong")
+	assert.Error(t, err)
+}